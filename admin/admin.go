@@ -0,0 +1,194 @@
+// Package admin serves the HTTP API used by operators to add, pause, resume and remove the
+// streamed topics while the service is running.
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/companieshouse/chs-streaming-api-cache/logger"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
+	"github.com/companieshouse/chs-streaming-api-cache/service"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/gorilla/pat"
+	"net/http"
+)
+
+// The path under which the topics are managed.
+const topicsPath = "/streaming-api-cache/admin/topics"
+
+// Topics manages the streamed topics.
+type Topics interface {
+	Add(topic *registry.Topic) (service.TopicState, error)
+	Pause(name string) (service.TopicState, error)
+	Resume(name string) (service.TopicState, error)
+	Remove(ctx context.Context, name string) error
+	Topic(name string) (service.TopicState, error)
+	Topics() []service.TopicState
+	Subscribers(name string) ([]service.SubscriberState, error)
+	Disconnect(name string, id string) error
+}
+
+// The body of an error response.
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// A Handler serves the admin API.
+type Handler struct {
+	topics Topics
+	logger logger.Logger
+}
+
+// Create a new Handler managing the given topics.
+func NewHandler(topics Topics, logger logger.Logger) *Handler {
+	return &Handler{
+		topics: topics,
+		logger: logger,
+	}
+}
+
+// Register the admin API with the router. Every endpoint requires an admin API key.
+func (h *Handler) Register(router *pat.Router, authenticator *auth.Authenticator) {
+	router.Path(topicsPath).Methods("GET").HandlerFunc(authenticator.AdminHandler(h.HandleList))
+	router.Path(topicsPath).Methods("POST").HandlerFunc(authenticator.AdminHandler(h.HandleAdd))
+	router.Path(topicsPath + "/{name}").Methods("GET").HandlerFunc(authenticator.AdminHandler(h.HandleGet))
+	router.Path(topicsPath + "/{name}").Methods("DELETE").HandlerFunc(authenticator.AdminHandler(h.HandleRemove))
+	router.Path(topicsPath + "/{name}/pause").Methods("POST").HandlerFunc(authenticator.AdminHandler(h.HandlePause))
+	router.Path(topicsPath + "/{name}/resume").Methods("POST").HandlerFunc(authenticator.AdminHandler(h.HandleResume))
+	router.Path(topicsPath + "/{name}/subscribers").Methods("GET").HandlerFunc(authenticator.AdminHandler(h.HandleListSubscribers))
+	router.Path(topicsPath + "/{name}/subscribers/{id}").Methods("DELETE").HandlerFunc(authenticator.AdminHandler(h.HandleDisconnect))
+}
+
+// HandleList responds with the state of every topic.
+func (h *Handler) HandleList(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, h.topics.Topics())
+}
+
+// HandleAdd starts streaming the topic in the body of the request, responding with 201 and its
+// state, 400 if the topic is invalid, or 409 if one with the same name or path already exists.
+func (h *Handler) HandleAdd(writer http.ResponseWriter, request *http.Request) {
+	topic := &registry.Topic{}
+	decoder := json.NewDecoder(request.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(topic); err != nil {
+		writeJSON(writer, http.StatusBadRequest, errorResponse{Error: "invalid topic: " + err.Error()})
+		return
+	}
+	state, err := h.topics.Add(topic)
+	if err == service.ErrTopicExists {
+		writeJSON(writer, http.StatusConflict, errorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, service.ErrCacheUnavailable) {
+		h.logger.Error(err, h.data(request, topic.Name))
+		writeJSON(writer, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(writer, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+	h.logger.InfoR(request, "Admin added topic", h.data(request, topic.Name))
+	writeJSON(writer, http.StatusCreated, state)
+}
+
+// HandleGet responds with the state of the named topic.
+func (h *Handler) HandleGet(writer http.ResponseWriter, request *http.Request) {
+	state, err := h.topics.Topic(nameOf(request))
+	h.respond(writer, state, err)
+}
+
+// HandlePause pauses ingestion of the named topic, responding with its state.
+func (h *Handler) HandlePause(writer http.ResponseWriter, request *http.Request) {
+	state, err := h.topics.Pause(nameOf(request))
+	if err == nil {
+		h.logger.InfoR(request, "Admin paused topic", h.data(request, state.Name))
+	}
+	h.respond(writer, state, err)
+}
+
+// HandleResume resumes ingestion of the named topic, responding with its state.
+func (h *Handler) HandleResume(writer http.ResponseWriter, request *http.Request) {
+	state, err := h.topics.Resume(nameOf(request))
+	if err == nil {
+		h.logger.InfoR(request, "Admin resumed topic", h.data(request, state.Name))
+	}
+	h.respond(writer, state, err)
+}
+
+// HandleRemove stops streaming the named topic, disconnecting its users, and responds with 204.
+func (h *Handler) HandleRemove(writer http.ResponseWriter, request *http.Request) {
+	name := nameOf(request)
+	err := h.topics.Remove(request.Context(), name)
+	if err == service.ErrTopicNotFound {
+		writeJSON(writer, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		// The topic has been removed, but its users may not all have been disconnected cleanly.
+		h.logger.Error(err, h.data(request, name))
+	}
+	h.logger.InfoR(request, "Admin removed topic", h.data(request, name))
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+// HandleListSubscribers responds with the users subscribed to the named topic.
+func (h *Handler) HandleListSubscribers(writer http.ResponseWriter, request *http.Request) {
+	subscribers, err := h.topics.Subscribers(nameOf(request))
+	if err == service.ErrTopicNotFound {
+		writeJSON(writer, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(writer, http.StatusOK, subscribers)
+}
+
+// HandleDisconnect disconnects a user from the named topic, sending it an end of stream marker,
+// and responds with 204.
+func (h *Handler) HandleDisconnect(writer http.ResponseWriter, request *http.Request) {
+	name := nameOf(request)
+	id := request.URL.Query().Get(":id")
+	err := h.topics.Disconnect(name, id)
+	if err == service.ErrTopicNotFound || err == broker.ErrSubscriberNotFound {
+		writeJSON(writer, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	if err != nil {
+		h.logger.Error(err, h.data(request, name))
+		writeJSON(writer, http.StatusServiceUnavailable, errorResponse{Error: err.Error()})
+		return
+	}
+	data := h.data(request, name)
+	data["subscriber"] = id
+	h.logger.InfoR(request, "Admin disconnected subscriber", data)
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) respond(writer http.ResponseWriter, state service.TopicState, err error) {
+	if err == service.ErrTopicNotFound {
+		writeJSON(writer, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(writer, http.StatusOK, state)
+}
+
+func (h *Handler) data(request *http.Request, topic string) log.Data {
+	data := log.Data{"topic": topic}
+	if key, ok := auth.FromContext(request.Context()); ok {
+		data["key_name"] = key.Name
+	}
+	return data
+}
+
+// The name of the topic in the request's path, as registered by the router.
+func nameOf(request *http.Request) string {
+	return request.URL.Query().Get(":name")
+}
+
+func writeJSON(writer http.ResponseWriter, code int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(body)
+}
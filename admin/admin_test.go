@@ -0,0 +1,208 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
+	"github.com/companieshouse/chs-streaming-api-cache/service"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/gorilla/pat"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type mockTopics struct {
+	mock.Mock
+}
+
+func (m *mockTopics) Add(topic *registry.Topic) (service.TopicState, error) {
+	args := m.Called(topic)
+	return args.Get(0).(service.TopicState), args.Error(1)
+}
+
+func (m *mockTopics) Pause(name string) (service.TopicState, error) {
+	args := m.Called(name)
+	return args.Get(0).(service.TopicState), args.Error(1)
+}
+
+func (m *mockTopics) Resume(name string) (service.TopicState, error) {
+	args := m.Called(name)
+	return args.Get(0).(service.TopicState), args.Error(1)
+}
+
+func (m *mockTopics) Remove(ctx context.Context, name string) error {
+	args := m.Called(name)
+	return args.Error(0)
+}
+
+func (m *mockTopics) Topic(name string) (service.TopicState, error) {
+	args := m.Called(name)
+	return args.Get(0).(service.TopicState), args.Error(1)
+}
+
+func (m *mockTopics) Topics() []service.TopicState {
+	args := m.Called()
+	return args.Get(0).([]service.TopicState)
+}
+
+func (m *mockTopics) Subscribers(name string) ([]service.SubscriberState, error) {
+	args := m.Called(name)
+	return args.Get(0).([]service.SubscriberState), args.Error(1)
+}
+
+func (m *mockTopics) Disconnect(name string, id string) error {
+	args := m.Called(name, id)
+	return args.Error(0)
+}
+
+type mockLogger struct {
+	mock.Mock
+}
+
+func (l *mockLogger) Error(err error, data ...log.Data) {
+	l.Called(err)
+}
+
+func (l *mockLogger) Info(msg string, data ...log.Data) {
+	l.Called(msg)
+}
+
+func (l *mockLogger) InfoR(req *http.Request, msg string, data ...log.Data) {
+	l.Called(req, msg)
+}
+
+// Make an admin request with the admin key.
+func serve(router *pat.Router, method string, path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.SetBasicAuth("admin", "")
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	return response
+}
+
+func TestAdminAPI(t *testing.T) {
+	Convey("Given the admin API has been registered", t, func() {
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything).Return()
+		logger.On("Error", mock.Anything).Return()
+		topics := &mockTopics{}
+		store := auth.NewMemoryKeyStore(&auth.Key{Key: "admin", Name: "operator", Admin: true}, &auth.Key{Key: "user", Name: "user"})
+		router := pat.New()
+		NewHandler(topics, logger).Register(router, auth.NewAuthenticator(store, logger))
+		running := service.TopicState{Name: "filings", Path: "/filings", State: service.StateRunning}
+		Convey("When the topics are listed with a key that is not an admin key", func() {
+			request := httptest.NewRequest("GET", "/streaming-api-cache/admin/topics", nil)
+			request.SetBasicAuth("user", "")
+			response := httptest.NewRecorder()
+			router.ServeHTTP(response, request)
+			Convey("Then the request should be forbidden", func() {
+				So(response.Code, ShouldEqual, http.StatusForbidden)
+				So(topics.AssertNotCalled(t, "Topics"), ShouldBeTrue)
+			})
+		})
+		Convey("When the topics are listed", func() {
+			topics.On("Topics").Return([]service.TopicState{running})
+			response := serve(router, "GET", "/streaming-api-cache/admin/topics", "")
+			Convey("Then the state of each topic should be returned", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Body.String(), ShouldContainSubstring, "\"name\":\"filings\"")
+				So(response.Body.String(), ShouldContainSubstring, "\"state\":\"running\"")
+			})
+		})
+		Convey("When a topic is added", func() {
+			topics.On("Add", mock.MatchedBy(func(topic *registry.Topic) bool {
+				return topic.Name == "filings" && topic.Path == "/filings" && topic.BackendPath == "/backend/filings"
+			})).Return(running, nil)
+			response := serve(router, "POST", "/streaming-api-cache/admin/topics", `{"name":"filings","path":"/filings","backend_path":"/backend/filings"}`)
+			Convey("Then it should be created", func() {
+				So(response.Code, ShouldEqual, http.StatusCreated)
+				So(response.Body.String(), ShouldContainSubstring, "\"name\":\"filings\"")
+			})
+		})
+		Convey("When a topic that already exists is added", func() {
+			topics.On("Add", mock.Anything).Return(service.TopicState{}, service.ErrTopicExists)
+			response := serve(router, "POST", "/streaming-api-cache/admin/topics", `{"name":"filings","path":"/filings","backend_path":"/backend/filings"}`)
+			Convey("Then it should conflict", func() {
+				So(response.Code, ShouldEqual, http.StatusConflict)
+			})
+		})
+		Convey("When a topic is added while its cache is unavailable", func() {
+			topics.On("Add", mock.Anything).Return(service.TopicState{}, fmt.Errorf("%w: connection refused", service.ErrCacheUnavailable))
+			response := serve(router, "POST", "/streaming-api-cache/admin/topics", `{"name":"filings","path":"/filings","backend_path":"/backend/filings"}`)
+			Convey("Then the service should be unavailable", func() {
+				So(response.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(response.Body.String(), ShouldContainSubstring, "\"error\":\"cache unavailable: connection refused\"")
+			})
+		})
+		Convey("When a topic with an unknown field is added", func() {
+			response := serve(router, "POST", "/streaming-api-cache/admin/topics", `{"name":"filings","colour":"blue"}`)
+			Convey("Then it should be rejected", func() {
+				So(response.Code, ShouldEqual, http.StatusBadRequest)
+				So(topics.AssertNotCalled(t, "Add", mock.Anything), ShouldBeTrue)
+			})
+		})
+		Convey("When a topic is paused", func() {
+			topics.On("Pause", "filings").Return(service.TopicState{Name: "filings", State: service.StatePaused}, nil)
+			response := serve(router, "POST", "/streaming-api-cache/admin/topics/filings/pause", "")
+			Convey("Then its paused state should be returned", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Body.String(), ShouldContainSubstring, "\"state\":\"paused\"")
+			})
+		})
+		Convey("When a topic is resumed", func() {
+			topics.On("Resume", "filings").Return(running, nil)
+			response := serve(router, "POST", "/streaming-api-cache/admin/topics/filings/resume", "")
+			Convey("Then its running state should be returned", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Body.String(), ShouldContainSubstring, "\"state\":\"running\"")
+			})
+		})
+		Convey("When an unknown topic is requested", func() {
+			topics.On("Topic", "unknown").Return(service.TopicState{}, service.ErrTopicNotFound)
+			response := serve(router, "GET", "/streaming-api-cache/admin/topics/unknown", "")
+			Convey("Then it should not be found", func() {
+				So(response.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+		Convey("When a topic is removed", func() {
+			topics.On("Remove", "filings").Return(nil)
+			response := serve(router, "DELETE", "/streaming-api-cache/admin/topics/filings", "")
+			Convey("Then it should respond with no content", func() {
+				So(response.Code, ShouldEqual, http.StatusNoContent)
+				So(topics.AssertCalled(t, "Remove", "filings"), ShouldBeTrue)
+			})
+		})
+		Convey("When the subscribers of a topic are listed", func() {
+			topics.On("Subscribers", "filings").Return([]service.SubscriberState{{ID: "7", RemoteAddress: "10.0.0.1:1234", Identity: "filings consumer", MessagesSent: 3, BytesSent: 120}}, nil)
+			response := serve(router, "GET", "/streaming-api-cache/admin/topics/filings/subscribers", "")
+			Convey("Then each subscriber should be described", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Body.String(), ShouldContainSubstring, "\"id\":\"7\"")
+				So(response.Body.String(), ShouldContainSubstring, "\"identity\":\"filings consumer\"")
+				So(response.Body.String(), ShouldContainSubstring, "\"messages_sent\":3")
+				So(response.Body.String(), ShouldContainSubstring, "\"bytes_sent\":120")
+			})
+		})
+		Convey("When a subscriber is disconnected", func() {
+			topics.On("Disconnect", "filings", "7").Return(nil)
+			response := serve(router, "DELETE", "/streaming-api-cache/admin/topics/filings/subscribers/7", "")
+			Convey("Then it should respond with no content", func() {
+				So(response.Code, ShouldEqual, http.StatusNoContent)
+				So(topics.AssertCalled(t, "Disconnect", "filings", "7"), ShouldBeTrue)
+			})
+		})
+		Convey("When an unknown subscriber is disconnected", func() {
+			topics.On("Disconnect", "filings", "8").Return(broker.ErrSubscriberNotFound)
+			response := serve(router, "DELETE", "/streaming-api-cache/admin/topics/filings/subscribers/8", "")
+			Convey("Then it should not be found", func() {
+				So(response.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+	})
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"github.com/companieshouse/chs.go/log"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type mockLogger struct {
+	mock.Mock
+}
+
+func (l *mockLogger) Error(err error, data ...log.Data) {
+	l.Called(err, data)
+}
+
+func (l *mockLogger) Info(msg string, data ...log.Data) {
+	l.Called(msg, data)
+}
+
+func (l *mockLogger) InfoR(req *http.Request, msg string, data ...log.Data) {
+	l.Called(req, msg, data)
+}
+
+func TestLoadKeysFromFile(t *testing.T) {
+	Convey("Given a file of api keys", t, func() {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		So(os.WriteFile(path, []byte(`[{"key":"abc","name":"filings only","paths":["/filings"]},{"key":"def","name":"everything"}]`), 0600), ShouldBeNil)
+		Convey("When a key store is loaded from the file", func() {
+			store, err := NewFileKeyStore(path)
+			So(err, ShouldBeNil)
+			Convey("Then the keys should be looked up with the paths they may access", func() {
+				filings, err := store.Lookup("abc")
+				So(err, ShouldBeNil)
+				So(filings.Allows("/filings"), ShouldBeTrue)
+				So(filings.Allows("/charges"), ShouldBeFalse)
+				everything, err := store.Lookup("def")
+				So(err, ShouldBeNil)
+				So(everything.Allows("/charges"), ShouldBeTrue)
+				_, err = store.Lookup("ghi")
+				So(err, ShouldEqual, ErrUnknownKey)
+			})
+		})
+	})
+}
+
+func TestAuthenticateRequests(t *testing.T) {
+	Convey("Given a stream handler requiring authentication", t, func() {
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		store := NewMemoryKeyStore(&Key{Key: "abc", Name: "filings only", Paths: []string{"/filings"}})
+		var authenticated *Key
+		handler := NewAuthenticator(store, logger).Handler("/filings", func(writer http.ResponseWriter, request *http.Request) {
+			authenticated, _ = FromContext(request.Context())
+		})
+		charges := NewAuthenticator(store, logger).Handler("/charges", func(writer http.ResponseWriter, request *http.Request) {})
+		Convey("When a request is made without credentials", func() {
+			response := httptest.NewRecorder()
+			handler(response, httptest.NewRequest("GET", "/filings", nil))
+			Convey("Then it should be challenged", func() {
+				So(response.Code, ShouldEqual, http.StatusUnauthorized)
+				So(response.Header().Get("WWW-Authenticate"), ShouldEqual, "Basic realm=\"chs-streaming-api-cache\"")
+			})
+		})
+		Convey("When a request is made with an unknown key", func() {
+			request := httptest.NewRequest("GET", "/filings", nil)
+			request.SetBasicAuth("unknown", "")
+			response := httptest.NewRecorder()
+			handler(response, request)
+			Convey("Then it should be rejected", func() {
+				So(response.Code, ShouldEqual, http.StatusUnauthorized)
+			})
+		})
+		Convey("When a request is made with a key for a stream it may not access", func() {
+			request := httptest.NewRequest("GET", "/charges", nil)
+			request.SetBasicAuth("abc", "")
+			response := httptest.NewRecorder()
+			charges(response, request)
+			Convey("Then it should be forbidden", func() {
+				So(response.Code, ShouldEqual, http.StatusForbidden)
+			})
+		})
+		Convey("When a request is made with a key for the stream", func() {
+			request := httptest.NewRequest("GET", "/filings", nil)
+			request.SetBasicAuth("abc", "")
+			response := httptest.NewRecorder()
+			handler(response, request)
+			Convey("Then it should be passed on with the key in its context", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(authenticated, ShouldNotBeNil)
+				So(authenticated.Name, ShouldEqual, "filings only")
+			})
+		})
+	})
+}
+
+func TestAuthenticateAdminRequests(t *testing.T) {
+	Convey("Given an admin handler requiring authentication", t, func() {
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		store := NewMemoryKeyStore(&Key{Key: "abc", Name: "user"}, &Key{Key: "xyz", Name: "operator", Admin: true})
+		called := false
+		handler := NewAuthenticator(store, logger).AdminHandler(func(writer http.ResponseWriter, request *http.Request) {
+			called = true
+		})
+		Convey("When a request is made without credentials", func() {
+			response := httptest.NewRecorder()
+			handler(response, httptest.NewRequest("GET", "/admin/topics", nil))
+			Convey("Then it should be challenged", func() {
+				So(response.Code, ShouldEqual, http.StatusUnauthorized)
+				So(called, ShouldBeFalse)
+			})
+		})
+		Convey("When a request is made with a key that is not an admin key", func() {
+			request := httptest.NewRequest("GET", "/admin/topics", nil)
+			request.SetBasicAuth("abc", "")
+			response := httptest.NewRecorder()
+			handler(response, request)
+			Convey("Then it should be forbidden", func() {
+				So(response.Code, ShouldEqual, http.StatusForbidden)
+				So(called, ShouldBeFalse)
+			})
+		})
+		Convey("When a request is made with an admin key", func() {
+			request := httptest.NewRequest("GET", "/admin/topics", nil)
+			request.SetBasicAuth("xyz", "")
+			response := httptest.NewRecorder()
+			handler(response, request)
+			Convey("Then it should be passed on", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(called, ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,93 @@
+// Package auth authenticates the users of the cache streams by their API keys, and authorises the
+// streams each key may access.
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+// ErrUnknownKey is the error returned when looking up a key that is not in a key store.
+var ErrUnknownKey = errors.New("unknown api key")
+
+// An API key, and the stream paths it may access.
+type Key struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	// The paths of the streams this key may access; every stream if empty.
+	Paths []string `json:"paths"`
+	// Whether this key may use the admin API.
+	Admin bool `json:"admin"`
+}
+
+// Allows reports whether this key may access the stream at the given path.
+func (k *Key) Allows(path string) bool {
+	if len(k.Paths) == 0 {
+		return true
+	}
+	for _, allowed := range k.Paths {
+		if allowed == path {
+			return true
+		}
+	}
+	return false
+}
+
+// A KeyStore holds the API keys of the users of the cache streams.
+type KeyStore interface {
+	// Lookup returns the given key, or ErrUnknownKey if it is not in the store.
+	Lookup(key string) (*Key, error)
+}
+
+// A MemoryKeyStore holds keys in memory.
+type MemoryKeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*Key
+}
+
+// Create a new MemoryKeyStore holding the given keys.
+func NewMemoryKeyStore(keys ...*Key) *MemoryKeyStore {
+	store := &MemoryKeyStore{keys: make(map[string]*Key)}
+	for _, key := range keys {
+		store.Add(key)
+	}
+	return store
+}
+
+// Load a MemoryKeyStore from a JSON file holding an array of keys.
+func NewFileKeyStore(path string) (*MemoryKeyStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []*Key
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if key.Key == "" {
+			return nil, errors.New("api key file contains an empty key")
+		}
+	}
+	return NewMemoryKeyStore(keys...), nil
+}
+
+// Add a key to this store, replacing any key with the same value.
+func (s *MemoryKeyStore) Add(key *Key) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.keys[key.Key] = key
+}
+
+// Lookup returns the given key, or ErrUnknownKey if it is not in this store.
+func (s *MemoryKeyStore) Lookup(key string) (*Key, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	found, ok := s.keys[key]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return found, nil
+}
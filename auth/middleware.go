@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"github.com/companieshouse/chs-streaming-api-cache/logger"
+	"github.com/companieshouse/chs.go/log"
+	"net/http"
+)
+
+const realm = "chs-streaming-api-cache"
+
+type contextKey struct{}
+
+// An Authenticator authenticates requests by the API key given as the username of their basic
+// authentication credentials, with an empty password, as with the public streaming API.
+type Authenticator struct {
+	store  KeyStore
+	logger logger.Logger
+}
+
+// Create a new Authenticator validating keys against the given store.
+func NewAuthenticator(store KeyStore, logger logger.Logger) *Authenticator {
+	return &Authenticator{
+		store:  store,
+		logger: logger,
+	}
+}
+
+// Handler wraps the handler of the stream at the given path, responding with 401 to requests
+// without a known key and 403 to those with a key that may not access the stream. The key of an
+// authenticated request is added to its context.
+func (a *Authenticator) Handler(path string, next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		key, ok := a.authenticate(writer, request, path)
+		if !ok {
+			return
+		}
+		if !key.Allows(path) {
+			a.logger.InfoR(request, "Rejected request for a stream the api key may not access", log.Data{"path": path, "key_name": key.Name})
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(writer, request.WithContext(NewContext(request.Context(), key)))
+	}
+}
+
+// AdminHandler wraps a handler of the admin API, responding with 401 to requests without a known
+// key and 403 to those with a key that is not an admin key.
+func (a *Authenticator) AdminHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		key, ok := a.authenticate(writer, request, request.URL.Path)
+		if !ok {
+			return
+		}
+		if !key.Admin {
+			a.logger.InfoR(request, "Rejected admin request with a non-admin api key", log.Data{"path": request.URL.Path, "key_name": key.Name})
+			writer.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(writer, request.WithContext(NewContext(request.Context(), key)))
+	}
+}
+
+// Look up the key of the request, challenging the user if there is no known key.
+func (a *Authenticator) authenticate(writer http.ResponseWriter, request *http.Request, path string) (*Key, bool) {
+	username, _, ok := request.BasicAuth()
+	if !ok || username == "" {
+		a.logger.InfoR(request, "Rejected request without an api key", log.Data{"path": path})
+		a.challenge(writer)
+		return nil, false
+	}
+	key, err := a.store.Lookup(username)
+	if err != nil {
+		a.logger.InfoR(request, "Rejected request with an unknown api key", log.Data{"path": path})
+		a.challenge(writer)
+		return nil, false
+	}
+	return key, true
+}
+
+func (a *Authenticator) challenge(writer http.ResponseWriter) {
+	writer.Header().Set("WWW-Authenticate", "Basic realm=\""+realm+"\"")
+	writer.WriteHeader(http.StatusUnauthorized)
+}
+
+// NewContext returns a copy of the context holding the given key.
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key of an authenticated request from its context.
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
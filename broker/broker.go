@@ -2,22 +2,51 @@ package broker
 
 import (
 	"errors"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+const defaultBufferSize = 100
+
+// ErrBrokerStopped is the error returned when subscribing to a broker that has been stopped.
+var ErrBrokerStopped = errors.New("broker has been stopped")
+
 // A broker to which cache broker will send messages published to all subscribed users.
 type Broker struct {
 	userSubscribed   chan *Event
 	userUnsubscribed chan *Event
-	users            map[chan string]bool
-	data             chan string
+	usersListed      chan chan []*Subscriber
+	users            map[chan *Message]*Subscriber
+	data             chan *Message
+	stop             chan struct{}
+	stopOnce         sync.Once
+	done             chan struct{}
+	bufferSize       int
+	policy           OverflowPolicy
+	subscribers      int64
+	published        uint64
+	dropped          uint64
+	disconnected     uint64
+	lastID           uint64
 	wg               *sync.WaitGroup
 }
 
+// Counters describing the messages delivered by a broker.
+type Stats struct {
+	Subscribers  int
+	Published    uint64
+	Dropped      uint64
+	Disconnected uint64
+}
+
 // An event that has been emitted to the given broker instance.
 type Event struct {
-	stream chan string
-	result chan *Result
+	stream     chan *Message
+	subscriber *Subscriber
+	result     chan *Result
 }
 
 // The result of the event after it has been handled by the event handler.
@@ -31,30 +60,67 @@ func NewBroker() *Broker {
 	return &Broker{
 		userSubscribed:   make(chan *Event),
 		userUnsubscribed: make(chan *Event),
-		users:            make(map[chan string]bool),
-		data:             make(chan string),
+		usersListed:      make(chan chan []*Subscriber),
+		users:            make(map[chan *Message]*Subscriber),
+		data:             make(chan *Message),
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+		bufferSize:       defaultBufferSize,
+		policy:           DisconnectPolicy{},
 	}
 }
 
-// Subscribe a user to this broker.
-func (b *Broker) Subscribe() (chan string, error) {
-	stream := make(chan string)
+// Set the number of messages buffered for each subscriber before the overflow policy is applied.
+func (b *Broker) WithBufferSize(size int) *Broker {
+	if size > 0 {
+		b.bufferSize = size
+	}
+	return b
+}
+
+// Set the policy applied when a subscriber's buffer is full.
+func (b *Broker) WithOverflowPolicy(policy OverflowPolicy) *Broker {
+	b.policy = policy
+	return b
+}
+
+// Subscribe a user, described by the given subscriber, to this broker. A nil subscriber
+// subscribes an anonymous user.
+// If the broker has been stopped then an error will be returned.
+func (b *Broker) Subscribe(subscriber *Subscriber) (chan *Message, error) {
+	if subscriber == nil {
+		subscriber = &Subscriber{}
+	}
+	if subscriber.ID == "" {
+		subscriber.ID = strconv.FormatUint(atomic.AddUint64(&b.lastID, 1), 10)
+	}
+	if subscriber.StartedAt.IsZero() {
+		subscriber.StartedAt = time.Now()
+	}
+	stream := make(chan *Message, b.bufferSize)
+	subscriber.stream = stream
 	subscription := &Event{
-		stream: stream,
-		result: make(chan *Result),
+		stream:     stream,
+		subscriber: subscriber,
+		result:     make(chan *Result),
+	}
+	select {
+	case b.userSubscribed <- subscription:
+	case <-b.done:
+		return nil, ErrBrokerStopped
 	}
-	b.userSubscribed <- subscription
 	<-subscription.result
 	close(subscription.result)
 	return stream, nil
 }
 
-// Run this broker instance.
+// Run this broker instance until it is stopped.
 func (b *Broker) Run() {
 	for {
 		select {
 		case subscriber := <-b.userSubscribed:
-			b.users[subscriber.stream] = true
+			b.users[subscriber.stream] = subscriber.subscriber
+			atomic.AddInt64(&b.subscribers, 1)
 			subscriber.result <- &Result{}
 		case unsubscribed := <-b.userUnsubscribed:
 			if _, ok := b.users[unsubscribed.stream]; !ok {
@@ -64,26 +130,65 @@ func (b *Broker) Run() {
 				}
 				continue
 			}
-			delete(b.users, unsubscribed.stream)
-			close(unsubscribed.stream)
+			b.remove(unsubscribed.stream)
 			unsubscribed.result <- &Result{}
+		case listed := <-b.usersListed:
+			subscribers := make([]*Subscriber, 0, len(b.users))
+			for _, subscriber := range b.users {
+				subscribers = append(subscribers, subscriber)
+			}
+			listed <- subscribers
 		case data := <-b.data:
+			atomic.AddUint64(&b.published, 1)
+			for user := range b.users {
+				b.deliver(user, data)
+			}
+		case <-b.stop:
 			for user := range b.users {
-				user <- data
+				b.remove(user)
 			}
+			close(b.done)
+			return
 		}
 	}
 }
 
+// Deliver a message to a subscriber without waiting, applying the overflow policy if its buffer is full.
+func (b *Broker) deliver(user chan *Message, data *Message) {
+	select {
+	case user <- data:
+		return
+	default:
+	}
+	switch b.policy.Overflow(user, data) {
+	case Dropped:
+		atomic.AddUint64(&b.dropped, 1)
+	case Disconnected:
+		atomic.AddUint64(&b.disconnected, 1)
+		b.remove(user)
+	}
+}
+
+// Remove a subscriber, closing its stream.
+func (b *Broker) remove(user chan *Message) {
+	delete(b.users, user)
+	atomic.AddInt64(&b.subscribers, -1)
+	close(user)
+}
+
 // Unsubscribe a user from this broker.
 // If the user isn't subscribed to this broker then an error will be returned.
-func (b *Broker) Unsubscribe(consumer chan string) error {
+func (b *Broker) Unsubscribe(consumer chan *Message) error {
 	subscription := &Event{
 		stream: consumer,
 		result: make(chan *Result),
 	}
 	defer close(subscription.result)
-	b.userUnsubscribed <- subscription
+	select {
+	case b.userUnsubscribed <- subscription:
+	case <-b.done:
+		return ErrBrokerStopped
+	}
 	result := <-subscription.result
 	if result.hasErrors {
 		return errors.New(result.msg)
@@ -91,7 +196,70 @@ func (b *Broker) Unsubscribe(consumer chan string) error {
 	return nil
 }
 
+// Subscribers returns the users subscribed to this broker, in the order they subscribed. No users
+// are returned once the broker has been stopped.
+func (b *Broker) Subscribers() []*Subscriber {
+	listed := make(chan []*Subscriber, 1)
+	select {
+	case b.usersListed <- listed:
+	case <-b.done:
+		return nil
+	}
+	subscribers := <-listed
+	sort.Slice(subscribers, func(i, j int) bool {
+		if subscribers[i].StartedAt.Equal(subscribers[j].StartedAt) {
+			return subscribers[i].ID < subscribers[j].ID
+		}
+		return subscribers[i].StartedAt.Before(subscribers[j].StartedAt)
+	})
+	return subscribers
+}
+
+// Disconnect the user with the given subscriber ID, closing its stream so it can end its response.
+// If no such user is subscribed then ErrSubscriberNotFound will be returned.
+func (b *Broker) Disconnect(id string) error {
+	for _, subscriber := range b.Subscribers() {
+		if subscriber.ID != id {
+			continue
+		}
+		err := b.Unsubscribe(subscriber.stream)
+		if err != nil && err != ErrBrokerStopped {
+			// The user unsubscribed, or was disconnected, since the subscribers were listed.
+			return ErrSubscriberNotFound
+		}
+		return err
+	}
+	return ErrSubscriberNotFound
+}
+
 // Publish a message to all subscribed users.
-func (b *Broker) Publish(msg string) {
-	b.data <- msg
+// Messages published after the broker has been stopped are discarded.
+func (b *Broker) Publish(msg *Message) {
+	select {
+	case b.data <- msg:
+	case <-b.done:
+	}
+}
+
+// Stop this broker, closing the streams of all subscribed users so they can end their responses.
+func (b *Broker) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stop)
+	})
+}
+
+// Done returns a channel that is closed once the broker has stopped and all subscriptions have been closed.
+func (b *Broker) Done() <-chan struct{} {
+	return b.done
+}
+
+// Stats returns the number of subscribers to this broker, and the number of messages published to
+// it, dropped, and subscribers disconnected for falling behind.
+func (b *Broker) Stats() Stats {
+	return Stats{
+		Subscribers:  int(atomic.LoadInt64(&b.subscribers)),
+		Published:    atomic.LoadUint64(&b.published),
+		Dropped:      atomic.LoadUint64(&b.dropped),
+		Disconnected: atomic.LoadUint64(&b.disconnected),
+	}
 }
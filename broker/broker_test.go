@@ -19,7 +19,7 @@ func TestSubscribeUser(t *testing.T) {
 		broker := NewBroker()
 		go broker.Run()
 		Convey("When a user subscribes to the cache broker", func() {
-			user, err := broker.Subscribe()
+			user, err := broker.Subscribe(nil)
 			Convey("Then a new subscription should be created", func() {
 				So(user, ShouldNotBeNil)
 				So(err, ShouldBeNil)
@@ -33,7 +33,7 @@ func TestUnsubscribeUser(t *testing.T) {
 	Convey("Given a running broker instance with a subscribed consumer", t, func() {
 		broker := NewBroker()
 		go broker.Run()
-		consumer, _ := broker.Subscribe()
+		consumer, _ := broker.Subscribe(nil)
 		Convey("When the consumer unsubscribes", func() {
 			err := broker.Unsubscribe(consumer)
 			Convey("Then the user should be removed from the list of subscribers", func() {
@@ -49,7 +49,7 @@ func TestUnsubscribeUserReturnsErrorIfNotSubscribed(t *testing.T) {
 		broker := NewBroker()
 		go broker.Run()
 		Convey("When an unsubscribed user attempts to unsubscribe", func() {
-			err := broker.Unsubscribe(make(chan string))
+			err := broker.Unsubscribe(make(chan *Message))
 			Convey("Then an error should be returned", func() {
 				So(err.Error(), ShouldEqual, "Attempted to unsubscribe a user that was not subscribed")
 			})
@@ -61,11 +61,90 @@ func TestPublishMessage(t *testing.T) {
 	Convey("Given a running broker instance with a subscribed user", t, func() {
 		broker := NewBroker()
 		go broker.Run()
-		user, _ := broker.Subscribe()
+		user, _ := broker.Subscribe(nil)
 		Convey("When a message is published", func() {
-			broker.Publish("Hello world!")
+			broker.Publish(&Message{Data: "Hello world!"})
 			Convey("Then the message should be published to all subscribers", func() {
-				So(<-user, ShouldEqual, "Hello world!")
+				So((<-user).Data, ShouldEqual, "Hello world!")
+			})
+		})
+	})
+}
+
+func TestStopClosesSubscriptions(t *testing.T) {
+	Convey("Given a running broker instance with a subscribed user", t, func() {
+		broker := NewBroker()
+		go broker.Run()
+		user, _ := broker.Subscribe(nil)
+		Convey("When the broker is stopped", func() {
+			broker.Stop()
+			<-broker.Done()
+			Convey("Then the user's stream should be closed", func() {
+				_, ok := <-user
+				So(ok, ShouldBeFalse)
+				So(broker.users, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestSubscribeReturnsErrorIfBrokerStopped(t *testing.T) {
+	Convey("Given a broker instance that has been stopped", t, func() {
+		broker := NewBroker()
+		go broker.Run()
+		broker.Stop()
+		<-broker.Done()
+		Convey("When a user subscribes", func() {
+			user, err := broker.Subscribe(nil)
+			Convey("Then an error should be returned", func() {
+				So(user, ShouldBeNil)
+				So(err, ShouldEqual, ErrBrokerStopped)
+			})
+		})
+	})
+}
+
+func TestListAndDisconnectSubscribers(t *testing.T) {
+	Convey("Given a running broker instance with two subscribed users", t, func() {
+		broker := NewBroker()
+		go broker.Run()
+		first, _ := broker.Subscribe(&Subscriber{RemoteAddress: "10.0.0.1:1234", Identity: "filings consumer", Timepoint: 42})
+		second, _ := broker.Subscribe(nil)
+		Convey("When the subscribers are listed", func() {
+			subscribers := broker.Subscribers()
+			Convey("Then each should be described in the order they subscribed", func() {
+				So(subscribers, ShouldHaveLength, 2)
+				So(subscribers[0].ID, ShouldEqual, "1")
+				So(subscribers[0].RemoteAddress, ShouldEqual, "10.0.0.1:1234")
+				So(subscribers[0].Identity, ShouldEqual, "filings consumer")
+				So(subscribers[0].Timepoint, ShouldEqual, 42)
+				So(subscribers[0].StartedAt.IsZero(), ShouldBeFalse)
+				So(subscribers[1].ID, ShouldEqual, "2")
+			})
+		})
+		Convey("When a message is recorded as sent to a subscriber", func() {
+			subscriber := broker.Subscribers()[0]
+			subscriber.Sent(5)
+			subscriber.Sent(7)
+			Convey("Then the messages and bytes sent should be counted", func() {
+				So(subscriber.Messages(), ShouldEqual, 2)
+				So(subscriber.Bytes(), ShouldEqual, 12)
+			})
+		})
+		Convey("When a subscriber is disconnected", func() {
+			err := broker.Disconnect("1")
+			Convey("Then its stream should be closed and the other left subscribed", func() {
+				So(err, ShouldBeNil)
+				_, ok := <-first
+				So(ok, ShouldBeFalse)
+				So(broker.Subscribers(), ShouldHaveLength, 1)
+				So(broker.users, ShouldContainKey, second)
+			})
+		})
+		Convey("When an unknown subscriber is disconnected", func() {
+			err := broker.Disconnect("unknown")
+			Convey("Then an error should be returned", func() {
+				So(err, ShouldEqual, ErrSubscriberNotFound)
 			})
 		})
 	})
@@ -0,0 +1,15 @@
+package broker
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/delta"
+	"time"
+)
+
+// A message published to a broker, carrying a delta along with the offset it was cached at.
+type Message struct {
+	Offset     int64
+	Topic      string
+	Data       string
+	Attributes delta.Attributes
+	ReceivedAt time.Time
+}
@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DisconnectPolicyName = "disconnect"
+	DropOldestPolicyName = "drop-oldest"
+	BlockPolicyName      = "block"
+
+	defaultBlockTimeout = time.Second
+)
+
+// The outcome of publishing a message to a subscriber whose buffer is full.
+type Outcome int
+
+const (
+	// The message was delivered to the subscriber.
+	Delivered Outcome = iota
+	// A message was discarded so the subscriber remains connected but has missed data.
+	Dropped
+	// The subscriber should be disconnected.
+	Disconnected
+)
+
+// An OverflowPolicy decides what happens to a message published to a subscriber whose buffer is full.
+type OverflowPolicy interface {
+	Overflow(stream chan *Message, msg *Message) Outcome
+}
+
+// DisconnectPolicy disconnects slow subscribers, leaving them to reconnect from the offset they last received.
+type DisconnectPolicy struct{}
+
+func (p DisconnectPolicy) Overflow(stream chan *Message, msg *Message) Outcome {
+	return Disconnected
+}
+
+// DropOldestPolicy discards the oldest buffered message to make room for the new one.
+type DropOldestPolicy struct{}
+
+func (p DropOldestPolicy) Overflow(stream chan *Message, msg *Message) Outcome {
+	select {
+	case <-stream:
+	default:
+	}
+	select {
+	case stream <- msg:
+	default:
+	}
+	return Dropped
+}
+
+// BlockPolicy waits for the subscriber to make room in its buffer, disconnecting it if the timeout elapses first.
+type BlockPolicy struct {
+	Timeout time.Duration
+}
+
+func (p BlockPolicy) Overflow(stream chan *Message, msg *Message) Outcome {
+	timer := time.NewTimer(p.Timeout)
+	defer timer.Stop()
+	select {
+	case stream <- msg:
+		return Delivered
+	case <-timer.C:
+		return Disconnected
+	}
+}
+
+// Obtain the overflow policy with the given name; an empty name selects the DisconnectPolicy.
+func NewOverflowPolicy(name string, blockTimeout time.Duration) (OverflowPolicy, error) {
+	switch name {
+	case "", DisconnectPolicyName:
+		return DisconnectPolicy{}, nil
+	case DropOldestPolicyName:
+		return DropOldestPolicy{}, nil
+	case BlockPolicyName:
+		if blockTimeout <= 0 {
+			blockTimeout = defaultBlockTimeout
+		}
+		return BlockPolicy{Timeout: blockTimeout}, nil
+	}
+	return nil, fmt.Errorf("unknown overflow policy [%s]", name)
+}
@@ -0,0 +1,92 @@
+package broker
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestNewOverflowPolicy(t *testing.T) {
+	Convey("When overflow policies are obtained by name", t, func() {
+		Convey("Then the matching policy should be returned", func() {
+			policy, err := NewOverflowPolicy("", 0)
+			So(err, ShouldBeNil)
+			So(policy, ShouldResemble, DisconnectPolicy{})
+			policy, err = NewOverflowPolicy(DropOldestPolicyName, 0)
+			So(err, ShouldBeNil)
+			So(policy, ShouldResemble, DropOldestPolicy{})
+			policy, err = NewOverflowPolicy(BlockPolicyName, time.Second)
+			So(err, ShouldBeNil)
+			So(policy, ShouldResemble, BlockPolicy{Timeout: time.Second})
+		})
+		Convey("Then an error should be returned for an unknown policy", func() {
+			policy, err := NewOverflowPolicy("unknown", 0)
+			So(policy, ShouldBeNil)
+			So(err.Error(), ShouldEqual, "unknown overflow policy [unknown]")
+		})
+	})
+}
+
+func TestSlowSubscriberIsDisconnected(t *testing.T) {
+	Convey("Given a running broker with a subscriber whose buffer is full", t, func() {
+		broker := NewBroker().WithBufferSize(1).WithOverflowPolicy(DisconnectPolicy{})
+		go broker.Run()
+		slow, _ := broker.Subscribe(nil)
+		fast, _ := broker.Subscribe(nil)
+		broker.Publish(&Message{Data: "first"})
+		So((<-fast).Data, ShouldEqual, "first")
+		Convey("When another message is published", func() {
+			broker.Publish(&Message{Data: "second"})
+			awaitDelivery(broker)
+			Convey("Then the slow subscriber should be disconnected without holding up the others", func() {
+				So((<-fast).Data, ShouldEqual, "second")
+				So((<-slow).Data, ShouldEqual, "first")
+				_, ok := <-slow
+				So(ok, ShouldBeFalse)
+				So(broker.Stats(), ShouldResemble, Stats{Subscribers: 1, Published: 2, Disconnected: 1})
+			})
+		})
+	})
+}
+
+func TestOldestMessageIsDroppedForSlowSubscriber(t *testing.T) {
+	Convey("Given a running broker with a subscriber whose buffer is full", t, func() {
+		broker := NewBroker().WithBufferSize(1).WithOverflowPolicy(DropOldestPolicy{})
+		go broker.Run()
+		slow, _ := broker.Subscribe(nil)
+		broker.Publish(&Message{Data: "first"})
+		Convey("When another message is published", func() {
+			broker.Publish(&Message{Data: "second"})
+			broker.Publish(&Message{Data: "third"})
+			awaitDelivery(broker)
+			Convey("Then the oldest buffered message should be dropped", func() {
+				So((<-slow).Data, ShouldEqual, "third")
+				So(broker.Stats(), ShouldResemble, Stats{Subscribers: 1, Published: 3, Dropped: 2})
+			})
+		})
+	})
+}
+
+func TestSlowSubscriberIsDisconnectedAfterBlockTimeout(t *testing.T) {
+	Convey("Given a running broker with a subscriber whose buffer is full", t, func() {
+		broker := NewBroker().WithBufferSize(1).WithOverflowPolicy(BlockPolicy{Timeout: 10 * time.Millisecond})
+		go broker.Run()
+		slow, _ := broker.Subscribe(nil)
+		broker.Publish(&Message{Data: "first"})
+		Convey("When the subscriber does not catch up within the timeout", func() {
+			broker.Publish(&Message{Data: "second"})
+			broker.Publish(&Message{Data: "third"})
+			Convey("Then the subscriber should be disconnected", func() {
+				So((<-slow).Data, ShouldEqual, "first")
+				_, ok := <-slow
+				So(ok, ShouldBeFalse)
+				So(broker.Stats().Disconnected, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+// Round trip through the broker's run loop so that messages already published have been delivered.
+func awaitDelivery(broker *Broker) {
+	_ = broker.Unsubscribe(make(chan *Message))
+}
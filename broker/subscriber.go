@@ -0,0 +1,47 @@
+package broker
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrSubscriberNotFound is the error returned when disconnecting a user that is not subscribed.
+var ErrSubscriberNotFound = errors.New("subscriber not found")
+
+// A user subscribed to a broker, and the messages that have been sent to it.
+type Subscriber struct {
+	// Updated atomically, so kept first to be aligned on 32-bit platforms
+	messages uint64
+	bytes    uint64
+	// Assigned by the broker when the user subscribes, unless already set
+	ID            string
+	RemoteAddress string
+	// The name of the API key the user authenticated with, if any
+	Identity  string
+	RequestID string
+	StartedAt time.Time
+	// The offset the user asked to stream from, or 0 if it only receives new messages
+	Timepoint int64
+	stream    chan *Message
+}
+
+// Sent records a message of the given size as sent to the user. It is safe to call on a nil
+// subscriber.
+func (s *Subscriber) Sent(bytes int) {
+	if s == nil {
+		return
+	}
+	atomic.AddUint64(&s.messages, 1)
+	atomic.AddUint64(&s.bytes, uint64(bytes))
+}
+
+// Messages returns the number of messages sent to the user.
+func (s *Subscriber) Messages() uint64 {
+	return atomic.LoadUint64(&s.messages)
+}
+
+// Bytes returns the number of bytes of the messages sent to the user.
+func (s *Subscriber) Bytes() uint64 {
+	return atomic.LoadUint64(&s.bytes)
+}
@@ -1,84 +1,344 @@
 package cache
 
 import (
-	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
 	"github.com/mediocregopher/radix/v3"
 	"log"
 	"strconv"
+	"time"
 )
 
 const (
 	ZADD          = "ZADD"
 	ZRANGEBYSCORE = "ZRANGEBYSCORE"
+	ZRANGE        = "ZRANGE"
+	MGET          = "MGET"
 	SET           = "SET"
 	GET           = "GET"
 	EXPIRE        = "EXPIRE"
 )
 
+// The names of the cache backends.
+const (
+	RedisBackend  = "redis"
+	MemoryBackend = "memory"
+	TieredBackend = "tiered"
+)
+
 type Cacheable interface {
 	// Insert new entities into sorted sets with the offset number as the score
 	Create(key string, delta string, score int64) error
-	//Fetch a range of offsets using a specified offset number as the starting offset
-	Read(key string, offset int64) ([]string, error)
+	//Iterate over the cached offsets starting from the specified offset number
+	Read(key string, offset int64) Iterator
+	//Fetch the highest offset that has been cached, or zero if nothing has been cached
+	LatestOffset(key string) (int64, error)
+	//Fetch the oldest and newest offsets that have been cached, or zeros if nothing has been cached
+	OffsetRange(key string) (int64, int64, error)
+}
+
+// Writes a delta, its entry in the sorted set of offsets and the latest offset in a single atomic step.
+// The types of the existing keys are checked before anything is written, so that a failure cannot
+// leave an offset pointing at a missing delta or a delta without an expiry.
+//
+// If a maximum number of entries is given, the oldest offsets beyond it are removed along with their
+// deltas, up to the given number at a time. Any still beyond it are left for Prune to remove, so a
+// large backlog never blocks Redis or fails the write.
+//
+// KEYS: offsets sorted set, delta, latest offset
+// ARGV: offset, delta, expiry in seconds, maximum number of entries, maximum offsets to trim
+var createScript = radix.NewEvalScript(3, trimFunction+`
+local function typeOf(key)
+	local reply = redis.call("TYPE", key)
+	if type(reply) == "table" then
+		return reply.ok
+	end
+	return reply
+end
+
+local offsetsType = typeOf(KEYS[1])
+if offsetsType ~= "zset" and offsetsType ~= "none" then
+	return redis.error_reply("WRONGTYPE " .. KEYS[1] .. " is not a sorted set")
+end
+local deltaType = typeOf(KEYS[2])
+if deltaType ~= "string" and deltaType ~= "none" then
+	return redis.error_reply("WRONGTYPE " .. KEYS[2] .. " is not a string")
+end
+local latestType = typeOf(KEYS[3])
+if latestType ~= "string" and latestType ~= "none" then
+	return redis.error_reply("WRONGTYPE " .. KEYS[3] .. " is not a string")
+end
+
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[2], ARGV[2], "EX", ARGV[3])
+else
+	redis.call("SET", KEYS[2], ARGV[2])
+end
+redis.call("ZADD", KEYS[1], ARGV[1], KEYS[2])
+local latest = tonumber(redis.call("GET", KEYS[3]))
+if latest == nil or tonumber(ARGV[1]) > latest then
+	redis.call("SET", KEYS[3], ARGV[1])
+end
+
+trim(KEYS[1], tonumber(ARGV[4]), tonumber(ARGV[5]))
+return 0
+`)
+
+// Removes the oldest offsets of a sorted set beyond a maximum number of entries, along with their
+// deltas, removing no more than the given limit. Deltas are deleted in chunks, as Lua can unpack
+// only a few thousand values at once. Returns the number of offsets removed.
+const trimFunction = `
+local function trim(offsets, maxEntries, limit)
+	if maxEntries <= 0 then
+		return 0
+	end
+	local excess = math.min(redis.call("ZCARD", offsets) - maxEntries, limit)
+	if excess <= 0 then
+		return 0
+	end
+	local trimmed = redis.call("ZRANGE", offsets, 0, excess - 1)
+	for first = 1, #trimmed, 100 do
+		redis.call("DEL", unpack(trimmed, first, math.min(first + 99, #trimmed)))
+	end
+	redis.call("ZREMRANGEBYRANK", offsets, 0, excess - 1)
+	return excess
+end
+`
+
+// The number of offsets trimmed or examined by each run of the create, trim and prune scripts.
+const pruneBatchSize = 1000
+
+// Removes the oldest offsets of a sorted set beyond a maximum number of entries, along with their
+// deltas. Returns the number of offsets removed.
+//
+// KEYS: offsets sorted set
+// ARGV: maximum number of entries, maximum offsets to trim
+var trimScript = radix.NewEvalScript(1, trimFunction+`
+return trim(KEYS[1], tonumber(ARGV[1]), tonumber(ARGV[2]))
+`)
+
+// Removes the oldest offsets from the sorted set whose deltas have expired, stopping at the first
+// delta that still exists. Returns the number of offsets removed.
+//
+// KEYS: offsets sorted set
+// ARGV: number of offsets to examine
+var pruneScript = radix.NewEvalScript(1, `
+local members = redis.call("ZRANGE", KEYS[1], 0, tonumber(ARGV[1]) - 1)
+local expired = {}
+for _, member in ipairs(members) do
+	if redis.call("EXISTS", member) == 1 then
+		break
+	end
+	table.insert(expired, member)
+end
+if #expired > 0 then
+	redis.call("ZREM", KEYS[1], unpack(expired))
+end
+return #expired
+`)
+
+// A cache whose index of offsets can be pruned of entries that have expired or exceed its maximum.
+type Prunable interface {
+	Prune(key string) (int64, error)
 }
 
 type RedisCacheService struct {
-	pool            *radix.Pool
+	pool            radix.Client
 	expiryInSeconds int64
+	maxEntries      int64
+	pageSize        int
 }
 
-func NewRedisCacheService(network string, url string, size int, expiryInSeconds int64) Cacheable {
+// The number of entries fetched from redis at a time when none has been configured.
+const defaultPageSize = 500
 
-	pool, err := radix.NewPool(network, url, size)
+// Create a new RedisCacheService. If maxEntries is greater than zero, each key retains at most that
+// many of its most recent offsets. Entries are read pageSize at a time. Panics if the connection
+// pool cannot be created.
+func NewRedisCacheService(network string, url string, size int, expiryInSeconds int64, maxEntries int64, pageSize int) Cacheable {
+	service, err := OpenRedisCacheService(network, url, size, expiryInSeconds, maxEntries, pageSize)
 	if err != nil {
 		panic(err)
 	}
+	return service
+}
+
+// Create a new RedisCacheService as NewRedisCacheService does, returning an error if the connection
+// pool cannot be created, such as when Redis cannot be reached.
+func OpenRedisCacheService(network string, url string, size int, expiryInSeconds int64, maxEntries int64, pageSize int) (Cacheable, error) {
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+
+	pool, err := radix.NewPool(network, url, size)
+	if err != nil {
+		return nil, err
+	}
 	return &RedisCacheService{
 		pool:            pool,
 		expiryInSeconds: expiryInSeconds,
-	}
+		maxEntries:      maxEntries,
+		pageSize:        pageSize,
+	}, nil
 }
 
-func (r RedisCacheService) Create(key string, delta string, offset int64) error {
-	log.Printf("Creating new cache entries for key=%s", key)
-
+func (r RedisCacheService) Create(key string, delta string, offset int64) (err error) {
+	defer func(start time.Time) {
+		metrics.ObserveCacheOperation(key, "create", start, err)
+	}(time.Now())
 	offsetAsString := strconv.FormatInt(offset, 10)
-	offsetsKey := key + ":offsets"
-	log.Printf("Creating sorted set cache entry for key=%s and offset=%s", offsetsKey, offsetAsString)
 	deltaKey := key + ":" + offsetAsString
-	if err := r.pool.Do(radix.Cmd(nil, ZADD, offsetsKey, offsetAsString, deltaKey)); err != nil {
-		return err
+	expirySeconds := strconv.FormatInt(r.expiryInSeconds, 10)
+	log.Printf("Creating new cache entry for key=%s with expiry of %s seconds", deltaKey, expirySeconds)
+	maxEntries := strconv.FormatInt(r.maxEntries, 10)
+	return r.pool.Do(createScript.Cmd(nil, key+":offsets", deltaKey, key+":latest", offsetAsString, delta, expirySeconds, maxEntries, strconv.Itoa(pruneBatchSize)))
+}
+
+// Read the entries of the given key from the given offset onwards. Entries are fetched a page at a
+// time as the iterator advances, so memory use is bounded by the page size.
+func (r RedisCacheService) Read(key string, offset int64) Iterator {
+	return &redisIterator{
+		cache: r,
+		key:   key,
+		min:   strconv.FormatInt(offset, 10),
+	}
+}
+
+// An Iterator fetching a page of entries from redis whenever the previous page is exhausted.
+type redisIterator struct {
+	cache   RedisCacheService
+	key     string
+	min     string
+	page    []Entry
+	current Entry
+	last    bool
+	err     error
+}
+
+func (i *redisIterator) Next() bool {
+	for len(i.page) == 0 {
+		if i.last || i.err != nil {
+			return false
+		}
+		i.err = i.fetch()
 	}
+	i.current = i.page[0]
+	i.page = i.page[1:]
+	return true
+}
 
-	log.Printf("Creating new cache entry for key=%s", deltaKey)
-	expirySeconds := fmt.Sprint(r.expiryInSeconds)
-	if err := r.pool.Do(radix.Cmd(nil, SET, deltaKey, delta)); err != nil {
+func (i *redisIterator) Entry() Entry {
+	return i.current
+}
+
+func (i *redisIterator) Err() error {
+	return i.err
+}
+
+// Fetch the next page of offsets and the deltas they point to, skipping any deltas that have expired.
+func (i *redisIterator) fetch() (err error) {
+	defer func(start time.Time) {
+		metrics.ObserveCacheOperation(i.key, "read", start, err)
+	}(time.Now())
+
+	pageSize := strconv.Itoa(i.cache.pageSize)
+	var members []string
+	if err = i.cache.pool.Do(radix.Cmd(&members, ZRANGEBYSCORE, i.key+":offsets", i.min, "+inf", "WITHSCORES", "LIMIT", "0", pageSize)); err != nil {
 		return err
 	}
-	log.Printf("Setting expiry time of %s seconds for key=%s\n", expirySeconds, deltaKey)
-	if err := r.pool.Do(radix.Cmd(nil, EXPIRE, deltaKey, expirySeconds)); err != nil {
+	count := len(members) / 2
+	i.last = count < i.cache.pageSize
+	if count == 0 {
+		return nil
+	}
+	log.Printf("Retrieved %d cached entries for key=%s from offset=%s", count, i.key, i.min)
+
+	keys := make([]string, count)
+	offsets := make([]int64, count)
+	for index := 0; index < count; index++ {
+		keys[index] = members[2*index]
+		if offsets[index], err = strconv.ParseInt(members[2*index+1], 10, 64); err != nil {
+			return err
+		}
+	}
+	i.min = "(" + strconv.FormatInt(offsets[count-1], 10)
+
+	var deltas []string
+	if err = i.cache.pool.Do(radix.Cmd(&deltas, MGET, keys...)); err != nil {
 		return err
 	}
+	for index, delta := range deltas {
+		if len(delta) > 0 {
+			i.page = append(i.page, Entry{Offset: offsets[index], Delta: delta})
+		}
+	}
 	return nil
 }
 
-func (r RedisCacheService) Read(key string, offset int64) ([]string, error) {
-	offsetAsString := strconv.FormatInt(offset, 10)
-	var offsets []string
-	err := r.pool.Do(radix.Cmd(&offsets, ZRANGEBYSCORE, key+":offsets", offsetAsString, "inf"))
-	if err == nil {
-		log.Printf("Retrieved %d cached entries for key=%s and offset=%d", len(offsets), key, offset)
+func (r RedisCacheService) LatestOffset(key string) (offset int64, err error) {
+	defer func(start time.Time) {
+		metrics.ObserveCacheOperation(key, "latest_offset", start, err)
+	}(time.Now())
+	if err = r.pool.Do(radix.Cmd(&radix.MaybeNil{Rcv: &offset}, GET, key+":latest")); err != nil {
+		return 0, err
 	}
-	var deltas []string
-	for _, offset := range offsets {
-		var delta string
-		fmt.Printf("Reading delta for offset: %s\n", offset)
-		if err := r.pool.Do(radix.Cmd(&delta, GET, offset)); err != nil {
-			return nil, err
+	return offset, nil
+}
+
+// Close the connection pool used by this cache service.
+func (r RedisCacheService) Close() error {
+	return r.pool.Close()
+}
+
+// Ping the Redis server to check it can be reached.
+func (r RedisCacheService) Ping() error {
+	return r.pool.Do(radix.Cmd(nil, "PING"))
+}
+
+func (r RedisCacheService) OffsetRange(key string) (oldest int64, newest int64, err error) {
+	defer func(start time.Time) {
+		metrics.ObserveCacheOperation(key, "offset_range", start, err)
+	}(time.Now())
+	var first []string
+	if err = r.pool.Do(radix.Cmd(&first, ZRANGE, key+":offsets", "0", "0", "WITHSCORES")); err != nil {
+		return 0, 0, err
+	}
+	if len(first) < 2 {
+		return 0, 0, nil
+	}
+	if oldest, err = strconv.ParseInt(first[1], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if newest, err = r.LatestOffset(key); err != nil {
+		return 0, 0, err
+	}
+	return oldest, newest, nil
+}
+
+// Prune the offsets of the given key beyond the maximum number of entries, and those whose deltas
+// have expired, returning the number removed.
+func (r RedisCacheService) Prune(key string) (pruned int64, err error) {
+	defer func(start time.Time) {
+		metrics.ObserveCacheOperation(key, "prune", start, err)
+	}(time.Now())
+	maxEntries := strconv.FormatInt(r.maxEntries, 10)
+	for r.maxEntries > 0 {
+		var trimmed int64
+		if err = r.pool.Do(trimScript.Cmd(&trimmed, key+":offsets", maxEntries, strconv.Itoa(pruneBatchSize))); err != nil {
+			return pruned, err
 		}
-		if len(delta) > 0 {
-			deltas = append(deltas, delta)
+		pruned += trimmed
+		if trimmed < pruneBatchSize {
+			break
+		}
+	}
+	for {
+		var removed int64
+		if err = r.pool.Do(pruneScript.Cmd(&removed, key+":offsets", strconv.Itoa(pruneBatchSize))); err != nil {
+			return pruned, err
+		}
+		pruned += removed
+		if removed < pruneBatchSize {
+			return pruned, nil
 		}
 	}
-	return deltas, err
 }
@@ -0,0 +1,257 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mediocregopher/radix/v3"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func newTestCacheService(t *testing.T, expiryInSeconds int64, maxEntries int64, pageSize int) (*miniredis.Miniredis, *RedisCacheService) {
+	server, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewRedisCacheService("tcp", server.Addr(), 1, expiryInSeconds, maxEntries, pageSize).(*RedisCacheService)
+	return server, service
+}
+
+// A redis client counting the round trips made to the server.
+type countingClient struct {
+	radix.Client
+	roundTrips int
+}
+
+func (c *countingClient) Do(action radix.Action) error {
+	c.roundTrips++
+	return c.Client.Do(action)
+}
+
+func TestUnitCreateWritesDeltaAtomically(t *testing.T) {
+	Convey("Given a redis cache service", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 0)
+		defer server.Close()
+		Convey("When a delta is created", func() {
+			err := service.Create("topic", "{\"id\":1}", 12)
+			Convey("Then the delta, its offset and the latest offset should be written with an expiry on the delta", func() {
+				So(err, ShouldBeNil)
+				delta, _ := server.Get("topic:12")
+				So(delta, ShouldEqual, "{\"id\":1}")
+				So(server.TTL("topic:12"), ShouldEqual, 60*time.Second)
+				score, _ := server.ZScore("topic:offsets", "topic:12")
+				So(score, ShouldEqual, 12)
+				latest, _ := server.Get("topic:latest")
+				So(latest, ShouldEqual, "12")
+			})
+		})
+	})
+}
+
+func TestUnitCreateUsesSingleRoundTrip(t *testing.T) {
+	Convey("Given a redis cache service", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 0)
+		defer server.Close()
+		client := &countingClient{Client: service.pool}
+		service.pool = client
+		Convey("When a delta is created", func() {
+			err := service.Create("topic", "{\"id\":1}", 1)
+			Convey("Then only a single round trip should be made to redis", func() {
+				So(err, ShouldBeNil)
+				So(client.roundTrips, ShouldEqual, 1)
+			})
+		})
+	})
+}
+
+func TestUnitCreateLeavesNoPartialStateOnFailure(t *testing.T) {
+	Convey("Given a redis cache service whose latest offset key holds the wrong type", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 0)
+		defer server.Close()
+		_, _ = server.Lpush("topic:latest", "not an offset")
+		Convey("When a delta is created", func() {
+			err := service.Create("topic", "{\"id\":1}", 12)
+			Convey("Then an error should be returned and nothing should be written", func() {
+				So(err, ShouldNotBeNil)
+				So(server.Exists("topic:12"), ShouldBeFalse)
+				So(server.Exists("topic:offsets"), ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestUnitCreateDoesNotMoveLatestOffsetBackwards(t *testing.T) {
+	Convey("Given a redis cache service that has cached offset 20", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 0)
+		defer server.Close()
+		So(service.Create("topic", "{\"id\":20}", 20), ShouldBeNil)
+		Convey("When an older offset is created", func() {
+			So(service.Create("topic", "{\"id\":15}", 15), ShouldBeNil)
+			Convey("Then the latest offset should be unchanged", func() {
+				latest, err := service.LatestOffset("topic")
+				So(err, ShouldBeNil)
+				So(latest, ShouldEqual, 20)
+				oldest, newest, err := service.OffsetRange("topic")
+				So(err, ShouldBeNil)
+				So(oldest, ShouldEqual, 15)
+				So(newest, ShouldEqual, 20)
+			})
+		})
+	})
+}
+
+func TestUnitCreateTrimsOffsetsBeyondMaximumEntries(t *testing.T) {
+	Convey("Given a redis cache service retaining at most 3 entries", t, func() {
+		server, service := newTestCacheService(t, 60, 3, 0)
+		defer server.Close()
+		Convey("When 5 deltas are created", func() {
+			for offset := int64(1); offset <= 5; offset++ {
+				So(service.Create("topic", "{}", offset), ShouldBeNil)
+			}
+			Convey("Then only the 3 most recent offsets and their deltas should remain", func() {
+				members, _ := server.ZMembers("topic:offsets")
+				So(members, ShouldResemble, []string{"topic:3", "topic:4", "topic:5"})
+				So(server.Exists("topic:1"), ShouldBeFalse)
+				So(server.Exists("topic:2"), ShouldBeFalse)
+				So(server.Exists("topic:3"), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestUnitCreateTrimsLargeBacklogInBatches(t *testing.T) {
+	Convey("Given a redis index of 20000 offsets, far more than a trim batch", t, func() {
+		server, unlimited := newTestCacheService(t, 0, 0, 0)
+		defer server.Close()
+		for offset := 1; offset <= 20000; offset++ {
+			deltaKey := fmt.Sprintf("topic:%d", offset)
+			_ = server.Set(deltaKey, "{}")
+			_, _ = server.ZAdd("topic:offsets", float64(offset), deltaKey)
+		}
+		_ = unlimited.Close()
+		service := NewRedisCacheService("tcp", server.Addr(), 1, 0, 10, 0).(*RedisCacheService)
+		defer service.Close()
+		Convey("When a maximum of 10 entries is turned on and a delta created", func() {
+			err := service.Create("topic", "{}", 20001)
+			Convey("Then the delta should be cached and only one batch of the oldest offsets trimmed", func() {
+				So(err, ShouldBeNil)
+				members, _ := server.ZMembers("topic:offsets")
+				So(members, ShouldHaveLength, 20001-pruneBatchSize)
+				So(server.Exists("topic:1"), ShouldBeFalse)
+				So(server.Exists(fmt.Sprintf("topic:%d", pruneBatchSize)), ShouldBeFalse)
+				So(server.Exists(fmt.Sprintf("topic:%d", pruneBatchSize+1)), ShouldBeTrue)
+			})
+			Convey("And when the cache is pruned", func() {
+				pruned, err := service.Prune("topic")
+				Convey("Then the rest of the backlog should be trimmed", func() {
+					So(err, ShouldBeNil)
+					So(pruned, ShouldEqual, 20001-pruneBatchSize-10)
+					members, _ := server.ZMembers("topic:offsets")
+					So(members, ShouldHaveLength, 10)
+					So(members[0], ShouldEqual, "topic:19992")
+					So(server.Exists("topic:19991"), ShouldBeFalse)
+					So(server.Exists("topic:19992"), ShouldBeTrue)
+				})
+			})
+		})
+	})
+}
+
+func TestUnitPruneRemovesExpiredOffsets(t *testing.T) {
+	Convey("Given a redis cache service with some expired deltas", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 0)
+		defer server.Close()
+		for offset := int64(1); offset <= 3; offset++ {
+			So(service.Create("topic", "{}", offset), ShouldBeNil)
+		}
+		server.FastForward(61 * time.Second)
+		for offset := int64(4); offset <= 5; offset++ {
+			So(service.Create("topic", "{}", offset), ShouldBeNil)
+		}
+		Convey("When the cache is pruned", func() {
+			pruned, err := service.Prune("topic")
+			Convey("Then the offsets of expired deltas should be removed from the index", func() {
+				So(err, ShouldBeNil)
+				So(pruned, ShouldEqual, 3)
+				members, _ := server.ZMembers("topic:offsets")
+				So(members, ShouldResemble, []string{"topic:4", "topic:5"})
+				oldest, newest, _ := service.OffsetRange("topic")
+				So(oldest, ShouldEqual, 4)
+				So(newest, ShouldEqual, 5)
+			})
+		})
+	})
+}
+
+func readAll(iterator Iterator) []Entry {
+	var entries []Entry
+	for iterator.Next() {
+		entries = append(entries, iterator.Entry())
+	}
+	return entries
+}
+
+func TestUnitReadFetchesEntriesInPages(t *testing.T) {
+	Convey("Given a redis cache service reading 2 entries at a time", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 2)
+		defer server.Close()
+		for offset := int64(1); offset <= 5; offset++ {
+			So(service.Create("topic", fmt.Sprintf("{\"id\":%d}", offset), offset), ShouldBeNil)
+		}
+		client := &countingClient{Client: service.pool}
+		service.pool = client
+		Convey("When the entries from offset 2 are read", func() {
+			iterator := service.Read("topic", 2)
+			Convey("Then nothing should be fetched until the iterator is advanced", func() {
+				So(client.roundTrips, ShouldEqual, 0)
+			})
+			Convey("Then each page should be fetched with a single range and a single MGET", func() {
+				So(iterator.Next(), ShouldBeTrue)
+				So(iterator.Entry(), ShouldResemble, Entry{Offset: 2, Delta: "{\"id\":2}"})
+				So(client.roundTrips, ShouldEqual, 2)
+			})
+			Convey("Then all of the entries from the offset should be returned in order", func() {
+				So(readAll(iterator), ShouldResemble, []Entry{
+					{Offset: 2, Delta: "{\"id\":2}"},
+					{Offset: 3, Delta: "{\"id\":3}"},
+					{Offset: 4, Delta: "{\"id\":4}"},
+					{Offset: 5, Delta: "{\"id\":5}"},
+				})
+				So(iterator.Err(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestUnitReadSkipsExpiredEntries(t *testing.T) {
+	Convey("Given a redis cache service where some deltas have expired", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 2)
+		defer server.Close()
+		for offset := int64(1); offset <= 3; offset++ {
+			So(service.Create("topic", "{}", offset), ShouldBeNil)
+		}
+		server.FastForward(61 * time.Second)
+		So(service.Create("topic", "{}", 4), ShouldBeNil)
+		Convey("When the entries are read", func() {
+			entries := readAll(service.Read("topic", 0))
+			Convey("Then only the entries that still exist should be returned", func() {
+				So(entries, ShouldResemble, []Entry{{Offset: 4, Delta: "{}"}})
+			})
+		})
+	})
+}
+
+func TestUnitReadReturnsErrorFromRedis(t *testing.T) {
+	Convey("Given a redis cache service whose server has stopped", t, func() {
+		server, service := newTestCacheService(t, 60, 0, 2)
+		server.Close()
+		Convey("When the entries are read", func() {
+			iterator := service.Read("topic", 0)
+			Convey("Then the iterator should end with an error", func() {
+				So(iterator.Next(), ShouldBeFalse)
+				So(iterator.Err(), ShouldNotBeNil)
+			})
+		})
+	})
+}
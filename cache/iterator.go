@@ -0,0 +1,51 @@
+package cache
+
+// An Entry is a delta cached against its offset.
+type Entry struct {
+	Offset int64
+	Delta  string
+}
+
+// An Iterator steps through cached entries in offset order.
+type Iterator interface {
+	// Advance to the next entry, returning false once there are no more entries or an error has occurred
+	Next() bool
+	// The entry the iterator is positioned at
+	Entry() Entry
+	// The error that ended the iteration, if any
+	Err() error
+}
+
+// An Iterator over entries already held in memory.
+type entryIterator struct {
+	entries []Entry
+	current Entry
+	err     error
+}
+
+// Create an Iterator over the given entries.
+func NewEntryIterator(entries ...Entry) Iterator {
+	return &entryIterator{entries: entries}
+}
+
+// Create an Iterator that returns no entries and the given error.
+func NewErrorIterator(err error) Iterator {
+	return &entryIterator{err: err}
+}
+
+func (i *entryIterator) Next() bool {
+	if i.err != nil || len(i.entries) == 0 {
+		return false
+	}
+	i.current = i.entries[0]
+	i.entries = i.entries[1:]
+	return true
+}
+
+func (i *entryIterator) Entry() Entry {
+	return i.current
+}
+
+func (i *entryIterator) Err() error {
+	return i.err
+}
@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// The number of entries retained for each key by a memory cache when no maximum has been configured.
+const defaultMemoryMaxEntries = 10000
+
+// A MemoryCacheService caches the most recent entries of each key in process, in a ring buffer of
+// bounded size per key. Entries older than the expiry are no longer read, and are removed when the
+// cache is pruned. Nothing is shared between instances of the service or survives a restart.
+type MemoryCacheService struct {
+	mutex      sync.RWMutex
+	rings      map[string]*ring
+	expiry     time.Duration
+	maxEntries int
+	pageSize   int
+	now        func() time.Time
+}
+
+// An entry held in a ring buffer, with the time it was cached.
+type memoryEntry struct {
+	Entry
+	created time.Time
+}
+
+// The entries of a key, in ascending offset order, oldest first.
+type ring struct {
+	entries []memoryEntry
+	start   int
+	count   int
+	// The highest offset cached, even if it has since been evicted or expired
+	latest int64
+}
+
+// Create a new MemoryCacheService. Each key retains at most maxEntries of its most recent offsets,
+// or 10000 if no maximum is given, for expiryInSeconds if given. Entries are read pageSize at a time.
+func NewMemoryCacheService(expiryInSeconds int64, maxEntries int64, pageSize int) Cacheable {
+	if maxEntries <= 0 {
+		maxEntries = defaultMemoryMaxEntries
+	}
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	return &MemoryCacheService{
+		rings:      make(map[string]*ring),
+		expiry:     time.Duration(expiryInSeconds) * time.Second,
+		maxEntries: int(maxEntries),
+		pageSize:   pageSize,
+		now:        time.Now,
+	}
+}
+
+func (m *MemoryCacheService) Create(key string, delta string, offset int64) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	r, ok := m.rings[key]
+	if !ok {
+		r = &ring{entries: make([]memoryEntry, m.maxEntries)}
+		m.rings[key] = r
+	}
+	r.add(memoryEntry{Entry: Entry{Offset: offset, Delta: delta}, created: m.now()})
+	return nil
+}
+
+// Read the entries of the given key from the given offset onwards. Entries are copied a page at a
+// time as the iterator advances, so entries cached before the last page is read are also returned.
+func (m *MemoryCacheService) Read(key string, offset int64) Iterator {
+	return &memoryIterator{
+		cache: m,
+		key:   key,
+		from:  offset,
+	}
+}
+
+func (m *MemoryCacheService) LatestOffset(key string) (int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, ok := m.rings[key]
+	if !ok {
+		return 0, nil
+	}
+	return r.latest, nil
+}
+
+func (m *MemoryCacheService) OffsetRange(key string) (int64, int64, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, ok := m.rings[key]
+	if !ok {
+		return 0, 0, nil
+	}
+	cutoff := m.cutoff()
+	for index := 0; index < r.count; index++ {
+		if entry := r.at(index); !entry.created.Before(cutoff) {
+			return entry.Offset, r.latest, nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// Prune the entries of the given key that have expired, returning the number removed.
+func (m *MemoryCacheService) Prune(key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	r, ok := m.rings[key]
+	if !ok {
+		return 0, nil
+	}
+	cutoff := m.cutoff()
+	var pruned int64
+	for r.count > 0 && r.at(0).created.Before(cutoff) {
+		r.removeOldest()
+		pruned++
+	}
+	return pruned, nil
+}
+
+// Entries cached before the cutoff have expired.
+func (m *MemoryCacheService) cutoff() time.Time {
+	if m.expiry <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(-m.expiry)
+}
+
+// Copy up to a page of the unexpired entries of the given key from the given offset onwards.
+func (m *MemoryCacheService) page(key string, from int64) (page []Entry, last bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, ok := m.rings[key]
+	if !ok {
+		return nil, true
+	}
+	return m.pageOf(r, from)
+}
+
+// Copy up to a page of entries as page does, reporting whether the entries of the given key still
+// reach back to the given offset. If not, entries from it onwards may have been evicted and no
+// page is copied.
+func (m *MemoryCacheService) pageIfHeld(key string, from int64) (page []Entry, last bool, held bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	r, ok := m.rings[key]
+	if !ok || r.count == 0 || r.at(0).Offset > from {
+		return nil, false, false
+	}
+	page, last = m.pageOf(r, from)
+	return page, last, true
+}
+
+func (m *MemoryCacheService) pageOf(r *ring, from int64) (page []Entry, last bool) {
+	cutoff := m.cutoff()
+	index := r.search(from)
+	for ; index < r.count && len(page) < m.pageSize; index++ {
+		if entry := r.at(index); !entry.created.Before(cutoff) {
+			page = append(page, entry.Entry)
+		}
+	}
+	return page, len(page) < m.pageSize
+}
+
+// The entry at the given position, counting from the oldest.
+func (r *ring) at(index int) memoryEntry {
+	return r.entries[(r.start+index)%len(r.entries)]
+}
+
+func (r *ring) set(index int, entry memoryEntry) {
+	r.entries[(r.start+index)%len(r.entries)] = entry
+}
+
+// The position of the first entry with an offset no lower than the given offset.
+func (r *ring) search(offset int64) int {
+	return sort.Search(r.count, func(index int) bool {
+		return r.at(index).Offset >= offset
+	})
+}
+
+// Add an entry, evicting the oldest if the ring is full. An entry for an offset already held
+// replaces it, and one older than the newest is inserted in order.
+func (r *ring) add(entry memoryEntry) {
+	if entry.Offset > r.latest {
+		r.latest = entry.Offset
+	}
+	index := r.search(entry.Offset)
+	if index < r.count && r.at(index).Offset == entry.Offset {
+		r.set(index, entry)
+		return
+	}
+	if r.count == len(r.entries) {
+		if index == 0 {
+			// Older than everything retained, so it would be evicted straight away.
+			return
+		}
+		r.removeOldest()
+		index--
+	}
+	r.count++
+	for position := r.count - 1; position > index; position-- {
+		r.set(position, r.at(position-1))
+	}
+	r.set(index, entry)
+}
+
+func (r *ring) removeOldest() {
+	r.entries[r.start] = memoryEntry{}
+	r.start = (r.start + 1) % len(r.entries)
+	r.count--
+}
+
+// An Iterator copying a page of entries from memory whenever the previous page is exhausted.
+type memoryIterator struct {
+	cache   *MemoryCacheService
+	key     string
+	from    int64
+	page    []Entry
+	current Entry
+	last    bool
+}
+
+func (i *memoryIterator) Next() bool {
+	for len(i.page) == 0 {
+		if i.last {
+			return false
+		}
+		i.page, i.last = i.cache.page(i.key, i.from)
+		if len(i.page) > 0 {
+			i.from = i.page[len(i.page)-1].Offset + 1
+		}
+	}
+	i.current = i.page[0]
+	i.page = i.page[1:]
+	return true
+}
+
+func (i *memoryIterator) Entry() Entry {
+	return i.current
+}
+
+func (i *memoryIterator) Err() error {
+	return nil
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func newTestMemoryCacheService(expiryInSeconds int64, maxEntries int64, pageSize int) (*MemoryCacheService, *time.Time) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	service := NewMemoryCacheService(expiryInSeconds, maxEntries, pageSize).(*MemoryCacheService)
+	service.now = func() time.Time {
+		return now
+	}
+	return service, &now
+}
+
+func TestUnitMemoryReadReturnsEntriesFromOffset(t *testing.T) {
+	Convey("Given a memory cache service holding offsets 1 to 5", t, func() {
+		service, _ := newTestMemoryCacheService(60, 0, 2)
+		for offset := int64(1); offset <= 5; offset++ {
+			_ = service.Create("topic", fmt.Sprintf("{\"id\":%d}", offset), offset)
+		}
+		Convey("When the entries are read from offset 2", func() {
+			entries := readAll(service.Read("topic", 2))
+			Convey("Then offsets 2 to 5 should be returned in order, a page at a time", func() {
+				So(entries, ShouldResemble, []Entry{
+					{Offset: 2, Delta: "{\"id\":2}"},
+					{Offset: 3, Delta: "{\"id\":3}"},
+					{Offset: 4, Delta: "{\"id\":4}"},
+					{Offset: 5, Delta: "{\"id\":5}"},
+				})
+			})
+		})
+		Convey("When the offsets are requested", func() {
+			oldest, newest, err := service.OffsetRange("topic")
+			latest, _ := service.LatestOffset("topic")
+			Convey("Then the oldest and newest offsets should be returned", func() {
+				So(err, ShouldBeNil)
+				So(oldest, ShouldEqual, 1)
+				So(newest, ShouldEqual, 5)
+				So(latest, ShouldEqual, 5)
+			})
+		})
+		Convey("When an unknown key is read", func() {
+			entries := readAll(service.Read("unknown", 1))
+			oldest, newest, _ := service.OffsetRange("unknown")
+			Convey("Then nothing should be returned", func() {
+				So(entries, ShouldBeEmpty)
+				So(oldest, ShouldEqual, 0)
+				So(newest, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestUnitMemoryCreateEvictsOldestBeyondMaximumEntries(t *testing.T) {
+	Convey("Given a memory cache service retaining 3 entries", t, func() {
+		service, _ := newTestMemoryCacheService(60, 3, 0)
+		Convey("When 5 offsets are created", func() {
+			for offset := int64(1); offset <= 5; offset++ {
+				_ = service.Create("topic", fmt.Sprintf("{\"id\":%d}", offset), offset)
+			}
+			Convey("Then only the 3 most recent should be retained", func() {
+				So(readAll(service.Read("topic", 0)), ShouldResemble, []Entry{
+					{Offset: 3, Delta: "{\"id\":3}"},
+					{Offset: 4, Delta: "{\"id\":4}"},
+					{Offset: 5, Delta: "{\"id\":5}"},
+				})
+				oldest, newest, _ := service.OffsetRange("topic")
+				So(oldest, ShouldEqual, 3)
+				So(newest, ShouldEqual, 5)
+			})
+		})
+	})
+}
+
+func TestUnitMemoryCreateKeepsOffsetsInOrder(t *testing.T) {
+	Convey("Given a memory cache service holding offsets 1, 3 and 5", t, func() {
+		service, _ := newTestMemoryCacheService(60, 4, 0)
+		_ = service.Create("topic", "one", 1)
+		_ = service.Create("topic", "three", 3)
+		_ = service.Create("topic", "five", 5)
+		Convey("When an older offset and an existing offset are created", func() {
+			_ = service.Create("topic", "four", 4)
+			_ = service.Create("topic", "THREE", 3)
+			Convey("Then the older offset should be inserted in order and the existing one replaced", func() {
+				So(readAll(service.Read("topic", 0)), ShouldResemble, []Entry{
+					{Offset: 1, Delta: "one"},
+					{Offset: 3, Delta: "THREE"},
+					{Offset: 4, Delta: "four"},
+					{Offset: 5, Delta: "five"},
+				})
+				latest, _ := service.LatestOffset("topic")
+				So(latest, ShouldEqual, 5)
+			})
+			Convey("And when an offset is created once the buffer is full", func() {
+				_ = service.Create("topic", "two", 2)
+				Convey("Then the oldest offset should be evicted", func() {
+					So(readAll(service.Read("topic", 0)), ShouldResemble, []Entry{
+						{Offset: 2, Delta: "two"},
+						{Offset: 3, Delta: "THREE"},
+						{Offset: 4, Delta: "four"},
+						{Offset: 5, Delta: "five"},
+					})
+				})
+			})
+		})
+	})
+}
+
+func TestUnitMemoryExpiredEntriesAreSkippedAndPruned(t *testing.T) {
+	Convey("Given a memory cache service with a 60 second expiry", t, func() {
+		service, now := newTestMemoryCacheService(60, 0, 0)
+		_ = service.Create("topic", "old", 1)
+		*now = now.Add(45 * time.Second)
+		_ = service.Create("topic", "new", 2)
+		Convey("When the first entry has expired", func() {
+			*now = now.Add(30 * time.Second)
+			Convey("Then it should no longer be read", func() {
+				So(readAll(service.Read("topic", 0)), ShouldResemble, []Entry{{Offset: 2, Delta: "new"}})
+				oldest, newest, _ := service.OffsetRange("topic")
+				So(oldest, ShouldEqual, 2)
+				So(newest, ShouldEqual, 2)
+			})
+			Convey("Then it should be removed when the cache is pruned", func() {
+				pruned, err := service.Prune("topic")
+				So(err, ShouldBeNil)
+				So(pruned, ShouldEqual, 1)
+				So(service.rings["topic"].count, ShouldEqual, 1)
+			})
+		})
+		Convey("When every entry has expired", func() {
+			*now = now.Add(time.Hour)
+			oldest, newest, _ := service.OffsetRange("topic")
+			latest, _ := service.LatestOffset("topic")
+			Convey("Then no range should be returned, but the latest offset should be kept", func() {
+				So(oldest, ShouldEqual, 0)
+				So(newest, ShouldEqual, 0)
+				So(latest, ShouldEqual, 2)
+			})
+		})
+	})
+}
+
+func TestUnitMemoryReadReturnsEntriesCachedWhileReading(t *testing.T) {
+	Convey("Given a memory cache service being read a page at a time", t, func() {
+		service, _ := newTestMemoryCacheService(60, 0, 1)
+		_ = service.Create("topic", "one", 1)
+		iterator := service.Read("topic", 0)
+		So(iterator.Next(), ShouldBeTrue)
+		Convey("When an offset is created before the next page is read", func() {
+			_ = service.Create("topic", "two", 2)
+			Convey("Then it should be returned", func() {
+				So(iterator.Next(), ShouldBeTrue)
+				So(iterator.Entry(), ShouldResemble, Entry{Offset: 2, Delta: "two"})
+				So(iterator.Next(), ShouldBeFalse)
+				So(iterator.Err(), ShouldBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
+)
+
+// The tiers of a tiered cache, as labelled in its metrics.
+const (
+	hotTier  = "hot"
+	coldTier = "cold"
+)
+
+// A TieredCacheService caches every delta in a cold cache, such as Redis, and the most recent in a
+// hot cache in memory. Reads from an offset still held in the hot cache are served from it; reads
+// from older offsets are served from the cold cache until they reach the offsets held in the hot
+// cache, and then from the hot cache. Reads fall back to the cold cache whenever the offsets they
+// have yet to return are evicted from the hot cache. The cold cache remains the record of the
+// offsets cached.
+type TieredCacheService struct {
+	hot  *MemoryCacheService
+	cold Cacheable
+}
+
+// Create a new TieredCacheService serving recent offsets from the hot cache and older offsets from
+// the cold cache.
+func NewTieredCacheService(hot *MemoryCacheService, cold Cacheable) Cacheable {
+	return &TieredCacheService{
+		hot:  hot,
+		cold: cold,
+	}
+}
+
+// Create a delta in the cold cache and then, once it has been cached there, in the hot cache, so
+// that the hot cache never holds an offset the cold cache does not.
+func (t *TieredCacheService) Create(key string, delta string, offset int64) error {
+	if err := t.cold.Create(key, delta, offset); err != nil {
+		return err
+	}
+	return t.hot.Create(key, delta, offset)
+}
+
+// Read the entries of the given key from the given offset onwards, in offset order, from whichever
+// tier holds them.
+func (t *TieredCacheService) Read(key string, offset int64) Iterator {
+	oldest, _, err := t.hot.OffsetRange(key)
+	if err == nil && oldest > 0 && offset >= oldest {
+		metrics.CacheReads.WithLabelValues(key, hotTier, "hit").Inc()
+		return &tieredIterator{
+			cache: t,
+			key:   key,
+			onHot: true,
+			next:  offset,
+		}
+	}
+	metrics.CacheReads.WithLabelValues(key, hotTier, "miss").Inc()
+	return &tieredIterator{
+		cache:    t,
+		key:      key,
+		cold:     t.cold.Read(key, offset),
+		next:     offset,
+		switchAt: oldest,
+	}
+}
+
+func (t *TieredCacheService) LatestOffset(key string) (int64, error) {
+	return t.cold.LatestOffset(key)
+}
+
+func (t *TieredCacheService) OffsetRange(key string) (int64, int64, error) {
+	return t.cold.OffsetRange(key)
+}
+
+// Prune the expired entries of the given key from both tiers, returning the number removed.
+func (t *TieredCacheService) Prune(key string) (int64, error) {
+	var pruned int64
+	for _, tier := range []Cacheable{t.hot, t.cold} {
+		if pruner, ok := tier.(Prunable); ok {
+			removed, err := pruner.Prune(key)
+			pruned += removed
+			if err != nil {
+				return pruned, err
+			}
+		}
+	}
+	return pruned, nil
+}
+
+// Ping the cold cache, if it can be pinged, to check it can be reached.
+func (t *TieredCacheService) Ping() error {
+	if pinger, ok := t.cold.(interface{ Ping() error }); ok {
+		return pinger.Ping()
+	}
+	return nil
+}
+
+// Close the cold cache, if it can be closed.
+func (t *TieredCacheService) Close() error {
+	if closer, ok := t.cold.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// An Iterator reading from the cold tier until it reaches an offset held in the hot tier, and from
+// the hot tier a page at a time from then on, falling back to the cold tier whenever the next
+// offset has been evicted from the hot tier.
+type tieredIterator struct {
+	cache *TieredCacheService
+	key   string
+	cold  Iterator
+	// Whether the hot tier is being read
+	onHot bool
+	page  []Entry
+	last  bool
+	// Whether the next page of the hot tier should be read even if the next offset has been
+	// evicted from it, as the cold tier has no more offsets to return
+	force bool
+	// Whether the cold tier has returned any entries since it was last read from
+	coldReturned bool
+	// The next offset to be returned
+	next int64
+	// The oldest offset in the hot tier when last checked, or 0 if it was empty
+	switchAt int64
+	// Whether the hit or miss of the cold tier has been recorded
+	recorded bool
+	current  Entry
+}
+
+func (i *tieredIterator) Next() bool {
+	if i.onHot {
+		return i.nextHot()
+	}
+	if !i.cold.Next() {
+		i.record(false)
+		if i.cold.Err() != nil {
+			return false
+		}
+		// Anything cached since the cold tier was read is also in the hot tier, unless it has since
+		// been evicted from it, in which case the cold tier is read again from the next offset. The
+		// hot tier is only read regardless once the cold tier has nothing more to return.
+		i.toHot(i.next)
+		i.force = !i.coldReturned
+		return i.Next()
+	}
+	i.record(true)
+	i.coldReturned = true
+	entry := i.cold.Entry()
+	if i.switchAt > 0 && entry.Offset >= i.switchAt && i.switchTo(entry.Offset) {
+		return i.Next()
+	}
+	i.current = entry
+	i.next = entry.Offset + 1
+	return true
+}
+
+// Return the next entry of the hot tier, copying a page of entries from it whenever the previous
+// page is exhausted, unless the next offset has been evicted from it in the meantime.
+func (i *tieredIterator) nextHot() bool {
+	for len(i.page) == 0 {
+		if i.last {
+			return false
+		}
+		page, last, held := i.cache.hot.pageIfHeld(i.key, i.next)
+		if !held && !i.force {
+			i.toCold()
+			return i.Next()
+		}
+		if !held {
+			page, last = i.cache.hot.page(i.key, i.next)
+		}
+		i.page, i.last, i.force = page, last, false
+		if len(page) > 0 {
+			i.next = page[len(page)-1].Offset + 1
+		}
+	}
+	i.current = i.page[0]
+	i.page = i.page[1:]
+	return true
+}
+
+// Switch to the hot tier from the given offset if it still holds it, as older offsets may have
+// been evicted while the cold tier was being read.
+func (i *tieredIterator) switchTo(offset int64) bool {
+	oldest, _, err := i.cache.hot.OffsetRange(i.key)
+	if err != nil || oldest == 0 || oldest > offset {
+		i.switchAt = oldest
+		return false
+	}
+	i.toHot(offset)
+	return true
+}
+
+func (i *tieredIterator) toHot(offset int64) {
+	i.onHot = true
+	i.next = offset
+	i.page, i.last = nil, false
+}
+
+// Read on from the cold tier, switching back to the hot tier once the offsets it holds are reached.
+func (i *tieredIterator) toCold() {
+	i.onHot = false
+	i.coldReturned = false
+	i.cold = i.cache.cold.Read(i.key, i.next)
+	i.switchAt, _, _ = i.cache.hot.OffsetRange(i.key)
+}
+
+// Record whether the cold tier held any of the offsets requested.
+func (i *tieredIterator) record(hit bool) {
+	if i.recorded {
+		return
+	}
+	i.recorded = true
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metrics.CacheReads.WithLabelValues(i.key, coldTier, result).Inc()
+}
+
+func (i *tieredIterator) Entry() Entry {
+	return i.current
+}
+
+func (i *tieredIterator) Err() error {
+	if i.onHot || i.cold == nil {
+		return nil
+	}
+	return i.cold.Err()
+}
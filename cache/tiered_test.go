@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func cacheReads(topic string, tier string, result string) float64 {
+	return testutil.ToFloat64(metrics.CacheReads.WithLabelValues(topic, tier, result))
+}
+
+func TestUnitTieredReadServesRecentOffsetsFromHotTier(t *testing.T) {
+	Convey("Given a tiered cache whose hot tier holds the 3 most recent of offsets 1 to 5", t, func() {
+		server, redis := newTestCacheService(t, 60, 0, 0)
+		defer server.Close()
+		tiered := NewTieredCacheService(NewMemoryCacheService(60, 3, 0).(*MemoryCacheService), redis)
+		for offset := int64(1); offset <= 5; offset++ {
+			So(tiered.Create("recent", fmt.Sprintf("{\"id\":%d}", offset), offset), ShouldBeNil)
+		}
+		// Mark the deltas held in redis, so the tier each is read from can be told apart.
+		for offset := int64(1); offset <= 5; offset++ {
+			_ = server.Set(fmt.Sprintf("recent:%d", offset), fmt.Sprintf("{\"cold\":%d}", offset))
+		}
+		hotHits, hotMisses := cacheReads("recent", "hot", "hit"), cacheReads("recent", "hot", "miss")
+		coldHits := cacheReads("recent", "cold", "hit")
+		Convey("When the entries are read from an offset held in the hot tier", func() {
+			entries := readAll(tiered.Read("recent", 4))
+			Convey("Then they should be read from the hot tier alone", func() {
+				So(entries, ShouldResemble, []Entry{
+					{Offset: 4, Delta: "{\"id\":4}"},
+					{Offset: 5, Delta: "{\"id\":5}"},
+				})
+				So(cacheReads("recent", "hot", "hit"), ShouldEqual, hotHits+1)
+				So(cacheReads("recent", "cold", "hit"), ShouldEqual, coldHits)
+			})
+		})
+		Convey("When the entries are read from an offset older than the hot tier holds", func() {
+			entries := readAll(tiered.Read("recent", 1))
+			Convey("Then the older offsets should be read from the cold tier and the rest from the hot tier, in order", func() {
+				So(entries, ShouldResemble, []Entry{
+					{Offset: 1, Delta: "{\"cold\":1}"},
+					{Offset: 2, Delta: "{\"cold\":2}"},
+					{Offset: 3, Delta: "{\"id\":3}"},
+					{Offset: 4, Delta: "{\"id\":4}"},
+					{Offset: 5, Delta: "{\"id\":5}"},
+				})
+				So(cacheReads("recent", "hot", "miss"), ShouldEqual, hotMisses+1)
+				So(cacheReads("recent", "cold", "hit"), ShouldEqual, coldHits+1)
+			})
+		})
+		Convey("When the offsets are requested", func() {
+			oldest, newest, err := tiered.OffsetRange("recent")
+			Convey("Then the offsets held in the cold tier should be returned", func() {
+				So(err, ShouldBeNil)
+				So(oldest, ShouldEqual, 1)
+				So(newest, ShouldEqual, 5)
+			})
+		})
+	})
+}
+
+func TestUnitTieredReadStaysOnColdTierWhileHotTierIsEvicted(t *testing.T) {
+	Convey("Given a tiered cache whose hot tier holds offsets 3 to 5 of 1 to 5", t, func() {
+		cold := NewMemoryCacheService(60, 0, 0)
+		tiered := NewTieredCacheService(NewMemoryCacheService(60, 3, 0).(*MemoryCacheService), cold)
+		for offset := int64(1); offset <= 5; offset++ {
+			_ = tiered.Create("evicted", fmt.Sprintf("%d", offset), offset)
+		}
+		Convey("When offsets 6 to 8 are cached while the cold tier is being read", func() {
+			iterator := tiered.Read("evicted", 1)
+			So(iterator.Next(), ShouldBeTrue)
+			for offset := int64(6); offset <= 8; offset++ {
+				_ = tiered.Create("evicted", fmt.Sprintf("%d", offset), offset)
+			}
+			entries := append([]Entry{iterator.Entry()}, readAll(iterator)...)
+			Convey("Then every offset should be read once and in order", func() {
+				So(entries, ShouldHaveLength, 8)
+				for index, entry := range entries {
+					So(entry.Offset, ShouldEqual, index+1)
+				}
+			})
+		})
+	})
+}
+
+func TestUnitTieredReadFallsBackToColdTierWhenHotTierIsEvictedAfterColdTierIsRead(t *testing.T) {
+	Convey("Given a tiered cache whose hot tier holds offset 13 of 1 to 13, and whose cold tier is read 3 at a time", t, func() {
+		cold := NewMemoryCacheService(60, 0, 3)
+		tiered := NewTieredCacheService(NewMemoryCacheService(60, 1, 0).(*MemoryCacheService), cold)
+		for offset := int64(1); offset <= 13; offset++ {
+			_ = tiered.Create("outrun", fmt.Sprintf("%d", offset), offset)
+		}
+		Convey("When offsets 14 to 17 are cached once the last page of the cold tier has been read", func() {
+			iterator := tiered.Read("outrun", 3)
+			for offset := int64(3); offset <= 12; offset++ {
+				So(iterator.Next(), ShouldBeTrue)
+			}
+			for offset := int64(14); offset <= 17; offset++ {
+				_ = tiered.Create("outrun", fmt.Sprintf("%d", offset), offset)
+			}
+			entries := readAll(iterator)
+			Convey("Then the offsets evicted from the hot tier should be read from the cold tier, in order", func() {
+				So(entries, ShouldHaveLength, 5)
+				for index, entry := range entries {
+					So(entry.Offset, ShouldEqual, index+13)
+				}
+				So(iterator.Err(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestUnitTieredReadFallsBackToColdTierWhileHotTierIsEvicted(t *testing.T) {
+	Convey("Given a tiered cache whose hot tier holds offsets 1 to 4 and is read 2 at a time", t, func() {
+		cold := NewMemoryCacheService(60, 0, 2)
+		tiered := NewTieredCacheService(NewMemoryCacheService(60, 4, 2).(*MemoryCacheService), cold)
+		for offset := int64(1); offset <= 4; offset++ {
+			_ = tiered.Create("overtaken", fmt.Sprintf("%d", offset), offset)
+		}
+		Convey("When offsets 5 to 10 are cached while the hot tier is being read", func() {
+			iterator := tiered.Read("overtaken", 1)
+			So(iterator.Next(), ShouldBeTrue)
+			So(iterator.Next(), ShouldBeTrue)
+			for offset := int64(5); offset <= 10; offset++ {
+				_ = tiered.Create("overtaken", fmt.Sprintf("%d", offset), offset)
+			}
+			entries := readAll(iterator)
+			Convey("Then the evicted offsets should be read from the cold tier, and every offset once and in order", func() {
+				So(entries, ShouldHaveLength, 8)
+				for index, entry := range entries {
+					So(entry.Offset, ShouldEqual, index+3)
+				}
+				So(iterator.Err(), ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestUnitTieredCreateSkipsHotTierIfColdTierFails(t *testing.T) {
+	Convey("Given a tiered cache whose cold tier cannot be written", t, func() {
+		server, redis := newTestCacheService(t, 60, 0, 0)
+		defer server.Close()
+		hot := NewMemoryCacheService(60, 3, 0).(*MemoryCacheService)
+		tiered := NewTieredCacheService(hot, redis)
+		server.SetError("failed")
+		Convey("When a delta is created", func() {
+			err := tiered.Create("failed", "{}", 1)
+			Convey("Then the error should be returned and nothing cached in the hot tier", func() {
+				So(err, ShouldNotBeNil)
+				latest, _ := hot.LatestOffset("failed")
+				So(latest, ShouldEqual, 0)
+			})
+		})
+	})
+}
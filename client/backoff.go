@@ -0,0 +1,59 @@
+package client
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultInitialInterval = 500 * time.Millisecond
+	defaultMaxInterval     = 30 * time.Second
+	defaultMultiplier      = 2
+	defaultJitter          = 0.2
+)
+
+// Backoff calculates how long to wait before each attempt to reconnect to the backend.
+type Backoff struct {
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	// Jitter is the fraction of each interval, between 0 and 1, that is randomised.
+	Jitter float64
+}
+
+// Create a new Backoff, falling back to defaults for any value that has not been configured. A zero
+// jitter disables randomisation, so only a negative jitter is treated as not configured.
+func NewBackoff(initialInterval time.Duration, maxInterval time.Duration, jitter float64) *Backoff {
+	if initialInterval <= 0 {
+		initialInterval = defaultInitialInterval
+	}
+	if maxInterval <= 0 {
+		maxInterval = defaultMaxInterval
+	}
+	if maxInterval < initialInterval {
+		maxInterval = initialInterval
+	}
+	if jitter < 0 || jitter > 1 {
+		jitter = defaultJitter
+	}
+	return &Backoff{
+		InitialInterval: initialInterval,
+		MaxInterval:     maxInterval,
+		Multiplier:      defaultMultiplier,
+		Jitter:          jitter,
+	}
+}
+
+// Duration returns the delay before the given (zero-based) reconnection attempt.
+func (b *Backoff) Duration(attempt int) time.Duration {
+	interval := float64(b.InitialInterval) * math.Pow(b.Multiplier, float64(attempt))
+	if interval > float64(b.MaxInterval) {
+		interval = float64(b.MaxInterval)
+	}
+	if b.Jitter > 0 {
+		delta := b.Jitter * interval
+		interval = interval - delta + rand.Float64()*2*delta
+	}
+	return time.Duration(interval)
+}
@@ -0,0 +1,52 @@
+package client
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestBackoffDurationGrowsExponentially(t *testing.T) {
+	Convey("given a backoff without jitter", t, func() {
+		backoff := &Backoff{InitialInterval: time.Second, MaxInterval: 10 * time.Second, Multiplier: 2}
+		Convey("then each attempt should wait twice as long as the last, up to the maximum", func() {
+			So(backoff.Duration(0), ShouldEqual, time.Second)
+			So(backoff.Duration(1), ShouldEqual, 2*time.Second)
+			So(backoff.Duration(2), ShouldEqual, 4*time.Second)
+			So(backoff.Duration(10), ShouldEqual, 10*time.Second)
+		})
+	})
+}
+
+func TestBackoffDurationIsJittered(t *testing.T) {
+	Convey("given a backoff with jitter", t, func() {
+		backoff := NewBackoff(time.Second, 10*time.Second, 0.5)
+		Convey("then the delay should stay within the jitter bounds", func() {
+			for i := 0; i < 100; i++ {
+				So(backoff.Duration(0), ShouldBeBetweenOrEqual, 500*time.Millisecond, 1500*time.Millisecond)
+			}
+		})
+	})
+}
+
+func TestNewBackoffAppliesDefaults(t *testing.T) {
+	Convey("when a backoff is created without configuration", t, func() {
+		backoff := NewBackoff(0, 0, -1)
+		Convey("then the default intervals should be used", func() {
+			So(backoff.InitialInterval, ShouldEqual, defaultInitialInterval)
+			So(backoff.MaxInterval, ShouldEqual, defaultMaxInterval)
+			So(backoff.Jitter, ShouldEqual, defaultJitter)
+		})
+	})
+}
+
+func TestBackoffWithoutJitter(t *testing.T) {
+	Convey("given a backoff whose jitter has been disabled", t, func() {
+		backoff := NewBackoff(time.Second, 10*time.Second, 0)
+		Convey("then each delay should not be randomised", func() {
+			So(backoff.Jitter, ShouldEqual, 0)
+			So(backoff.Duration(0), ShouldEqual, time.Second)
+			So(backoff.Duration(1), ShouldEqual, 2*time.Second)
+		})
+	})
+}
@@ -2,11 +2,20 @@ package client
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
 	. "github.com/companieshouse/chs-streaming-api-cache/cache"
+	"github.com/companieshouse/chs-streaming-api-cache/delta"
 	"github.com/companieshouse/chs-streaming-api-cache/logger"
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
 	"github.com/companieshouse/chs.go/log"
+	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -20,17 +29,44 @@ type Client struct {
 	cacheService Cacheable
 	key          string
 	logger       logger.Logger
-	wg           *sync.WaitGroup
+	backoff      *Backoff
+	idleTimeout  time.Duration
+	offset       int64
+	lastReceived time.Time
+	body         io.ReadCloser
+	mutex        sync.Mutex
+	stop         chan struct{}
+	stopOnce     sync.Once
+	paused       chan struct{}
+	pauses       int
+	// Cancels the request for the backend stream, so that one still being established is abandoned
+	cancel context.CancelFunc
+	wg     *sync.WaitGroup
 }
 
+// How long to wait for a connection to the backend, and then for the response to the request for
+// its stream, before giving up.
+const (
+	dialTimeout           = 10 * time.Second
+	responseHeaderTimeout = 30 * time.Second
+)
+
 type Publishable interface {
-	Publish(msg string)
+	Publish(msg *broker.Message)
 }
 
 type Doable interface {
 	Do(req *http.Request) (resp *http.Response, err error)
 }
 
+// The state of the backend stream.
+type Status struct {
+	Connected    bool
+	Paused       bool
+	LastReceived time.Time
+	Offset       int64
+}
+
 // The result of the operation.
 type Result struct {
 	Data   string `json:"data"`
@@ -47,36 +83,91 @@ func NewClient(baseurl string, path string, broker Publishable, client Doable, u
 		cacheService: service,
 		key:          key,
 		logger:       logger,
+		backoff:      NewBackoff(0, 0, -1),
+		stop:         make(chan struct{}),
 		wg:           nil,
 	}
 }
 
-func (c *Client) Connect() {
-	url := c.baseurl + c.path
-	req, _ := http.NewRequest("GET", url, nil)
+// NewHTTPClient returns an HTTP client for streaming from the backend, which gives up on a
+// connection that cannot be made, or a request that is not answered, within a timeout. No overall
+// timeout is set, as the stream is expected to stay open.
+func NewHTTPClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	return &http.Client{Transport: transport}
+}
+
+// Set the backoff used to delay attempts to reconnect to the backend.
+func (c *Client) WithBackoff(backoff *Backoff) *Client {
+	c.backoff = backoff
+	return c
+}
+
+// Set how long the backend stream may be silent before it is treated as dead and re-established.
+// A zero timeout disables idle detection.
+func (c *Client) WithIdleTimeout(timeout time.Duration) *Client {
+	c.idleTimeout = timeout
+	return c
+}
+
+// Connect to the backend stream, resuming from the offset after the last one cached.
+func (c *Client) Connect() (io.ReadCloser, error) {
+	streamURL, err := c.streamURL()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(c.connection(), "GET", streamURL, nil)
+	if err != nil {
+		return nil, err
+	}
 	req.SetBasicAuth(c.username, "")
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		c.logger.Error(err, log.Data{})
-		panic(err)
+		return nil, err
 	}
 	if resp.StatusCode != http.StatusOK {
-		c.logger.Info("Unable to stream from backend endpoint", log.Data{"endpoint": c.baseurl, "path": c.path, "Http Status": resp.StatusCode})
-		panic("Unable to stream from backend endpoint")
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unable to stream from backend endpoint: status %d", resp.StatusCode)
 	}
-	body := resp.Body
-	reader := bufio.NewReader(body)
-	go c.loop(reader)
+	return resp.Body, nil
 }
 
-func (c *Client) loop(reader *bufio.Reader) {
+func (c *Client) streamURL() (string, error) {
+	streamURL, err := url.Parse(c.baseurl + c.path)
+	if err != nil {
+		return "", err
+	}
+	if c.offset > 0 {
+		query := streamURL.Query()
+		query.Set("timepoint", strconv.FormatInt(c.offset+1, 10))
+		streamURL.RawQuery = query.Encode()
+	}
+	return streamURL.String(), nil
+}
+
+func (c *Client) loop(body io.ReadCloser) error {
+	reader := bufio.NewReader(body)
+	var watchdog *time.Timer
+	if c.idleTimeout > 0 {
+		watchdog = time.AfterFunc(c.idleTimeout, func() {
+			c.logger.Info("Backend stream idle, closing connection", log.Data{"topic": c.key, "idle_timeout": c.idleTimeout.String()})
+			_ = body.Close()
+		})
+		defer watchdog.Stop()
+	}
 
 	for {
 		line, err := reader.ReadBytes('\n')
 		if err != nil {
-			c.logger.Error(err, log.Data{})
-			continue
+			return err
 		}
+		if watchdog != nil {
+			watchdog.Reset(c.idleTimeout)
+		}
+		received := time.Now()
+		c.setLastReceived(received)
 		result := &Result{}
 		err = json.Unmarshal(line, result)
 		if err != nil {
@@ -88,7 +179,16 @@ func (c *Client) loop(reader *bufio.Reader) {
 			c.logger.Error(err, log.Data{})
 			continue
 		}
-		c.broker.Publish(result.Data)
+		c.setOffset(result.Offset)
+		metrics.DeltasIngested.WithLabelValues(c.key).Inc()
+		metrics.LatestOffset.WithLabelValues(c.key).Set(float64(result.Offset))
+		c.broker.Publish(&broker.Message{
+			Offset:     result.Offset,
+			Topic:      c.key,
+			Data:       result.Data,
+			Attributes: delta.Parse(result.Data),
+			ReceivedAt: received,
+		})
 		if c.wg != nil {
 			c.wg.Done()
 		}
@@ -96,6 +196,201 @@ func (c *Client) loop(reader *bufio.Reader) {
 	}
 }
 
+// Resume from the highest offset already cached for this topic, so nothing produced while
+// the service was down is missed.
+func (c *Client) resume() {
+	latest, err := c.cacheService.LatestOffset(c.key)
+	if err != nil {
+		c.logger.Error(err, log.Data{"topic": c.key})
+		return
+	}
+	if latest > c.offset {
+		c.logger.Info("Resuming backend stream from cached offset", log.Data{"topic": c.key, "offset": latest})
+		c.setOffset(latest)
+	}
+}
+
+// Run this client, re-establishing the backend stream with an exponential backoff whenever it drops,
+// until the client is stopped.
 func (c *Client) Run() {
-	c.Connect()
+	c.resume()
+	attempt := 0
+	for !c.stopped() {
+		if !c.waitWhilePaused() {
+			break
+		}
+		pauses := c.pauseCount()
+		body, err := c.Connect()
+		if err != nil {
+			if c.stopped() {
+				break
+			}
+			if c.pauseCount() == pauses {
+				c.logger.Error(err, log.Data{"endpoint": c.baseurl, "path": c.path, "topic": c.key})
+			}
+		} else if c.track(body) {
+			lastOffset := c.offset
+			err = c.loop(body)
+			c.track(nil)
+			_ = body.Close()
+			if c.stopped() {
+				break
+			}
+			if c.pauseCount() != pauses {
+				attempt = 0
+				continue
+			}
+			c.logger.Error(fmt.Errorf("backend stream closed: %v", err), log.Data{"topic": c.key, "offset": c.offset})
+			if c.offset != lastOffset {
+				attempt = 0
+			}
+		}
+		if c.pauseCount() != pauses {
+			continue
+		}
+		delay := c.backoff.Duration(attempt)
+		attempt++
+		metrics.BackendReconnects.WithLabelValues(c.key).Inc()
+		c.logger.Info("Reconnecting to backend stream", log.Data{"topic": c.key, "attempt": attempt, "delay": delay.String(), "offset": c.offset})
+		select {
+		case <-time.After(delay):
+		case <-c.stop:
+		}
+	}
+	c.logger.Info("Backend stream stopped", log.Data{"topic": c.key, "offset": c.offset})
+}
+
+// Stop this client, closing the backend stream if one is open.
+func (c *Client) Stop() {
+	c.stopOnce.Do(func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		close(c.stop)
+		if c.cancel != nil {
+			c.cancel()
+		}
+		if c.body != nil {
+			_ = c.body.Close()
+		}
+	})
+}
+
+// Pause ingestion, closing the backend stream if one is open. The stream is not re-established
+// until the client is resumed.
+func (c *Client) Pause() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.paused != nil {
+		return
+	}
+	c.paused = make(chan struct{})
+	c.pauses++
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.body != nil {
+		_ = c.body.Close()
+	}
+}
+
+// Resume ingestion, re-establishing the backend stream from the offset after the last one cached.
+func (c *Client) Resume() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.paused == nil {
+		return
+	}
+	close(c.paused)
+	c.paused = nil
+}
+
+// The number of times the client has been paused, so that a stream closed by a pause, even one
+// already resumed, is not treated as a failure.
+func (c *Client) pauseCount() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.pauses
+}
+
+// Block while the client is paused. Returns false if the client is stopped while waiting.
+func (c *Client) waitWhilePaused() bool {
+	c.mutex.Lock()
+	paused := c.paused
+	c.mutex.Unlock()
+	if paused == nil {
+		return true
+	}
+	c.logger.Info("Backend stream paused", log.Data{"topic": c.key, "offset": c.offset})
+	select {
+	case <-paused:
+		c.logger.Info("Backend stream resumed", log.Data{"topic": c.key, "offset": c.offset})
+		return true
+	case <-c.stop:
+		return false
+	}
+}
+
+// Create the context of a request for the backend stream, cancelling that of the previous request.
+// It is cancelled by Stop or Pause, or straight away if the client has already been stopped or paused.
+func (c *Client) connection() context.Context {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	var ctx context.Context
+	ctx, c.cancel = context.WithCancel(context.Background())
+	if c.stopped() || c.paused != nil {
+		c.cancel()
+	}
+	return ctx
+}
+
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Record the open backend stream so that it can be closed by Stop or Pause. Returns false, closing
+// the stream, if the client has already been stopped or paused.
+func (c *Client) track(body io.ReadCloser) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if body != nil && (c.stopped() || c.paused != nil) {
+		_ = body.Close()
+		return false
+	}
+	c.body = body
+	if body != nil {
+		c.lastReceived = time.Now()
+	}
+	return true
+}
+
+// Status returns the state of the backend stream.
+func (c *Client) Status() Status {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return Status{
+		Connected:    c.body != nil,
+		Paused:       c.paused != nil,
+		LastReceived: c.lastReceived,
+		Offset:       c.offset,
+	}
+}
+
+func (c *Client) setOffset(offset int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.offset = offset
+}
+
+func (c *Client) setLastReceived(received time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastReceived = received
 }
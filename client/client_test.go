@@ -1,24 +1,36 @@
 package client
 
 import (
+	"errors"
 	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/companieshouse/chs-streaming-api-cache/cache"
 	"github.com/companieshouse/chs.go/log"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
+	"io"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 type mockBroker struct {
 	mock.Mock
 }
 
-func (b *mockBroker) Publish(msg string) {
+func (b *mockBroker) Publish(msg *broker.Message) {
 	b.Called(msg)
 }
 
+// Match a message published with the given data and offset for the "key" topic.
+func publishedMessage(data string, offset int64) interface{} {
+	return mock.MatchedBy(func(msg *broker.Message) bool {
+		return msg.Data == data && msg.Offset == offset && msg.Topic == "key" && !msg.ReceivedAt.IsZero()
+	})
+}
+
 type mockHttpClient struct {
 	mock.Mock
 }
@@ -37,9 +49,19 @@ func (s *mockCacheService) Create(key string, delta string, offset int64) error
 	return args.Error(0)
 }
 
-func (s *mockCacheService) Read(key string, offset int64) ([]string, error) {
+func (s *mockCacheService) Read(key string, offset int64) cache.Iterator {
 	args := s.Called(key, offset)
-	return args.Get(0).([]string), args.Error(1)
+	return args.Get(0).(cache.Iterator)
+}
+
+func (s *mockCacheService) LatestOffset(key string) (int64, error) {
+	args := s.Called(key)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (s *mockCacheService) OffsetRange(key string) (int64, int64, error) {
+	args := s.Called(key)
+	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
 }
 
 type mockLogger struct {
@@ -47,7 +69,7 @@ type mockLogger struct {
 }
 
 func (l *mockLogger) Info(msg string, data ...log.Data) {
-	panic("implement me")
+	l.Called(msg)
 }
 
 func (l *mockLogger) InfoR(req *http.Request, message string, data ...log.Data) {
@@ -88,20 +110,258 @@ func TestPublishToBroker(t *testing.T) {
 		}, nil)
 		service := &mockCacheService{}
 		service.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		service.On("LatestOffset", "key").Return(int64(0), nil)
 		logger := &mockLogger{}
 		logger.On("Error", mock.Anything).Return(nil)
-		client := NewClient("baseurl", "path", broker, httpClient, "username", service, "key", logger)
+		logger.On("Info", mock.Anything).Return(nil)
+		client := NewClient("baseurl", "path", broker, httpClient, "username", service, "key", logger).
+			WithBackoff(&Backoff{InitialInterval: time.Hour, MaxInterval: time.Hour, Multiplier: 1})
 		client.wg = new(sync.WaitGroup)
 		Convey("when a new message is published", func() {
 			client.wg.Add(1)
-			client.Connect()
+			go client.Run()
 			client.wg.Wait()
 			Convey("Then the message should be written to the cache and forwarded to the broker", func() {
 				So(service.AssertCalled(t, "Create", "key", "{\"greetings\":\"hello\"}", int64(43)), ShouldBeTrue)
-				So(broker.AssertCalled(t, "Publish", "{\"greetings\":\"hello\"}"), ShouldBeTrue)
+				So(broker.AssertCalled(t, "Publish", publishedMessage("{\"greetings\":\"hello\"}", 43)), ShouldBeTrue)
+			})
+			Convey("Then the status should report the offset received", func() {
+				status := client.Status()
+				So(status.Offset, ShouldEqual, 43)
+				So(status.LastReceived, ShouldNotBeZeroValue)
+			})
+		})
+	})
+}
+
+func TestReconnectToBackendAfterError(t *testing.T) {
+	Convey("given a backend that is initially unavailable", t, func() {
+		broker := &mockBroker{}
+		broker.On("Publish", mock.Anything).Return()
+		httpClient := &mockHttpClient{}
+		httpClient.On("Do", mock.Anything).Return((*http.Response)(nil), errors.New("connection refused")).Once()
+		httpClient.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200,
+			Body: &mockBody{strings.NewReader("{\"data\":\"{\\\"greetings\\\":\\\"hello\\\"}\",\"offset\":43}\n")},
+		}, nil).Once()
+		httpClient.On("Do", mock.Anything).Return((*http.Response)(nil), errors.New("connection refused"))
+		service := &mockCacheService{}
+		service.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		service.On("LatestOffset", "key").Return(int64(0), nil)
+		logger := &mockLogger{}
+		logger.On("Error", mock.Anything).Return(nil)
+		logger.On("Info", mock.Anything).Return(nil)
+		client := NewClient("baseurl", "path", broker, httpClient, "username", service, "key", logger).
+			WithBackoff(&Backoff{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond, Multiplier: 1})
+		client.wg = new(sync.WaitGroup)
+		Convey("when the client is run", func() {
+			client.wg.Add(1)
+			go client.Run()
+			client.wg.Wait()
+			Convey("then the client should reconnect and forward the message to the broker", func() {
+				So(broker.AssertCalled(t, "Publish", publishedMessage("{\"greetings\":\"hello\"}", 43)), ShouldBeTrue)
 			})
 		})
 	})
 }
 
-//error handling if http client returns an error
+func TestReconnectToBackendResumesFromLastOffset(t *testing.T) {
+	Convey("given a backend stream that closes after delivering an offset", t, func() {
+		broker := &mockBroker{}
+		broker.On("Publish", mock.Anything).Return()
+		requests := make(chan *http.Request, 2)
+		httpClient := &mockHttpClient{}
+		httpClient.On("Do", mock.Anything).Run(func(args mock.Arguments) {
+			requests <- args.Get(0).(*http.Request)
+		}).Return(&http.Response{StatusCode: 200,
+			Body: &mockBody{strings.NewReader("{\"data\":\"{\\\"greetings\\\":\\\"hello\\\"}\",\"offset\":43}\n")},
+		}, nil).Once()
+		httpClient.On("Do", mock.Anything).Run(func(args mock.Arguments) {
+			select {
+			case requests <- args.Get(0).(*http.Request):
+			default:
+			}
+		}).Return((*http.Response)(nil), errors.New("connection refused"))
+		service := &mockCacheService{}
+		service.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		service.On("LatestOffset", "key").Return(int64(0), nil)
+		logger := &mockLogger{}
+		logger.On("Error", mock.Anything).Return(nil)
+		logger.On("Info", mock.Anything).Return(nil)
+		client := NewClient("http://backend", "/filings?timepoint=2", broker, httpClient, "username", service, "key", logger).
+			WithBackoff(&Backoff{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond, Multiplier: 1})
+		Convey("when the client reconnects", func() {
+			go client.Run()
+			first := <-requests
+			second := <-requests
+			Convey("then the configured timepoint should be replaced by the offset after the last one cached", func() {
+				So(first.URL.Query().Get("timepoint"), ShouldEqual, "2")
+				So(second.URL.Query().Get("timepoint"), ShouldEqual, "44")
+			})
+		})
+	})
+}
+
+func TestConnectReturnsErrorForUnexpectedStatus(t *testing.T) {
+	Convey("given a backend that responds with an error status", t, func() {
+		httpClient := &mockHttpClient{}
+		httpClient.On("Do", mock.Anything).Return(&http.Response{StatusCode: 503,
+			Body: &mockBody{strings.NewReader("")},
+		}, nil)
+		client := NewClient("baseurl", "path", &mockBroker{}, httpClient, "username", &mockCacheService{}, "key", &mockLogger{})
+		Convey("when the client connects", func() {
+			body, err := client.Connect()
+			Convey("then an error should be returned", func() {
+				So(body, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
+
+func TestConnectResumesFromCachedOffsetOnStartup(t *testing.T) {
+	Convey("given offsets have already been cached for the topic", t, func() {
+		requests := make(chan *http.Request, 1)
+		httpClient := &mockHttpClient{}
+		httpClient.On("Do", mock.Anything).Run(func(args mock.Arguments) {
+			select {
+			case requests <- args.Get(0).(*http.Request):
+			default:
+			}
+		}).Return((*http.Response)(nil), errors.New("connection refused"))
+		service := &mockCacheService{}
+		service.On("LatestOffset", "key").Return(int64(120), nil)
+		logger := &mockLogger{}
+		logger.On("Error", mock.Anything).Return(nil)
+		logger.On("Info", mock.Anything).Return(nil)
+		client := NewClient("http://backend", "/filings?timepoint=2", &mockBroker{}, httpClient, "username", service, "key", logger).
+			WithBackoff(&Backoff{InitialInterval: time.Hour, MaxInterval: time.Hour, Multiplier: 1})
+		Convey("when the client is run", func() {
+			go client.Run()
+			request := <-requests
+			Convey("then the backend should be asked for the offset after the last one cached", func() {
+				So(request.URL.Query().Get("timepoint"), ShouldEqual, "121")
+			})
+		})
+	})
+}
+
+func TestStopEndsRun(t *testing.T) {
+	Convey("given a client connected to a backend stream", t, func() {
+		body, writer := io.Pipe()
+		httpClient := &mockHttpClient{}
+		httpClient.On("Do", mock.Anything).Return(&http.Response{StatusCode: 200, Body: body}, nil)
+		service := &mockCacheService{}
+		service.On("LatestOffset", "key").Return(int64(0), nil)
+		service.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		broker := &mockBroker{}
+		broker.On("Publish", mock.Anything).Return()
+		logger := &mockLogger{}
+		logger.On("Error", mock.Anything).Return(nil)
+		logger.On("Info", mock.Anything).Return(nil)
+		client := NewClient("baseurl", "path", broker, httpClient, "username", service, "key", logger)
+		client.wg = new(sync.WaitGroup)
+		finished := make(chan struct{})
+		client.wg.Add(1)
+		go func() {
+			client.Run()
+			close(finished)
+		}()
+		_, _ = writer.Write([]byte("{\"data\":\"hello\",\"offset\":1}\n"))
+		client.wg.Wait()
+		Convey("when the client is stopped", func() {
+			client.Stop()
+			Convey("then the backend stream should be closed and the client should stop running", func() {
+				<-finished
+				_, err := writer.Write([]byte("\n"))
+				So(err, ShouldEqual, io.ErrClosedPipe)
+				So(httpClient.AssertNumberOfCalls(t, "Do", 1), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestStopAbandonsConnectionBeingEstablished(t *testing.T) {
+	Convey("given a client whose backend accepts the request for its stream but never responds", t, func() {
+		requested := make(chan struct{})
+		release := make(chan struct{})
+		backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			close(requested)
+			<-release
+		}))
+		defer backend.Close()
+		defer close(release)
+		service := &mockCacheService{}
+		service.On("LatestOffset", "key").Return(int64(0), nil)
+		logger := &mockLogger{}
+		logger.On("Error", mock.Anything).Return(nil)
+		logger.On("Info", mock.Anything).Return(nil)
+		client := NewClient(backend.URL, "/filings", &mockBroker{}, NewHTTPClient(), "username", service, "key", logger)
+		finished := make(chan struct{})
+		go func() {
+			client.Run()
+			close(finished)
+		}()
+		<-requested
+		Convey("when the client is stopped", func() {
+			client.Stop()
+			Convey("then the request should be abandoned and the client should stop running", func() {
+				select {
+				case <-finished:
+				case <-time.After(time.Second):
+					t.Fatal("client did not stop")
+				}
+				So(logger.AssertNotCalled(t, "Error", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestPauseAndResume(t *testing.T) {
+	Convey("given a client connected to a backend stream", t, func() {
+		body, writer := io.Pipe()
+		requests := make(chan *http.Request, 2)
+		httpClient := &mockHttpClient{}
+		httpClient.On("Do", mock.Anything).Run(func(args mock.Arguments) {
+			requests <- args.Get(0).(*http.Request)
+		}).Return(&http.Response{StatusCode: 200, Body: body}, nil).Once()
+		httpClient.On("Do", mock.Anything).Run(func(args mock.Arguments) {
+			select {
+			case requests <- args.Get(0).(*http.Request):
+			default:
+			}
+		}).Return((*http.Response)(nil), errors.New("connection refused"))
+		service := &mockCacheService{}
+		service.On("LatestOffset", "key").Return(int64(0), nil)
+		service.On("Create", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+		broker := &mockBroker{}
+		broker.On("Publish", mock.Anything).Return()
+		logger := &mockLogger{}
+		logger.On("Error", mock.Anything).Return(nil)
+		logger.On("Info", mock.Anything).Return(nil)
+		client := NewClient("http://backend", "/filings", broker, httpClient, "username", service, "key", logger).
+			WithBackoff(&Backoff{InitialInterval: time.Hour, MaxInterval: time.Hour, Multiplier: 1})
+		client.wg = new(sync.WaitGroup)
+		client.wg.Add(1)
+		go client.Run()
+		defer client.Stop()
+		<-requests
+		_, _ = writer.Write([]byte("{\"data\":\"hello\",\"offset\":1}\n"))
+		client.wg.Wait()
+		Convey("when the client is paused", func() {
+			client.Pause()
+			Convey("then the backend stream should be closed and the client should report it is paused", func() {
+				_, err := writer.Write([]byte("\n"))
+				So(err, ShouldEqual, io.ErrClosedPipe)
+				So(client.Status().Paused, ShouldBeTrue)
+			})
+			Convey("and when the client is resumed", func() {
+				client.Resume()
+				request := <-requests
+				Convey("then the backend stream should be re-established from the offset after the last one cached", func() {
+					So(request.URL.Query().Get("timepoint"), ShouldEqual, "2")
+					So(client.Status().Paused, ShouldBeFalse)
+				})
+			})
+		})
+	})
+}
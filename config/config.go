@@ -3,21 +3,42 @@ package config
 import "github.com/companieshouse/gofigure"
 
 type Config struct {
-	gofigure             interface{} `order:"env,flag"`
-	BindAddress          string      `env:"BIND_ADDRESS"                    flag:"bind-address"`
-	CertFile             string      `env:"CERT_FILE"                       flag:"cert-file" json:"-"`
-	KeyFile              string      `env:"KEY_FILE"                        flag:"key-file" json:"-"`
-	ChsApiKey            string      `env:"CHS_API_KEY"                     flag:"chs-api-key" json:"-"`
-	BackEndUrl           string      `env:"STREAMING_BACKEND_URL"           flag:"streaming_backend_url"`
-	RedisUrl             string      `env:"REDIS_URL"                       flag:"redis-url"`
-	RedisPoolSize        int         `env:"REDIS_POOL_SIZE"                 flag:"redis_pool_size"`
-	CacheExpiryInSeconds int64       `env:"CACHE_EXPIRY_IN_SECONDS"         flag:"cache-expiry-in-seconds"`
-	StreamFilingsPath    string      `env:"STREAM_BACKEND_FILINGS_PATH"     flag:"stream-backend-filings-path"`
-	StreamCompaniesPath  string      `env:"STREAM_BACKEND_COMPANIES_PATH"   flag:"stream-backend-companies-path"`
-	StreamInsolvencyPath string      `env:"STREAM_BACKEND_INSOLVENCY_PATH"  flag:"stream-backend-insolvency-path"`
-	StreamChargesPath    string      `env:"STREAM_BACKEND_CHARGES_PATH"     flag:"stream-backend-charges-path"`
-	StreamOfficersPath   string      `env:"STREAM_BACKEND_OFFICERS_PATH"    flag:"stream-backend-officers-path"`
-	StreamPSCsPath       string      `env:"STREAM_BACKEND_PSCS_PATH"        flag:"stream-backend-pscs-path"`
+	gofigure                       interface{} `order:"env,flag"`
+	BindAddress                    string      `env:"BIND_ADDRESS"                    flag:"bind-address"`
+	CertFile                       string      `env:"CERT_FILE"                       flag:"cert-file" json:"-"`
+	KeyFile                        string      `env:"KEY_FILE"                        flag:"key-file" json:"-"`
+	ChsApiKey                      string      `env:"CHS_API_KEY"                     flag:"chs-api-key" json:"-"`
+	BackEndUrl                     string      `env:"STREAMING_BACKEND_URL"           flag:"streaming_backend_url"`
+	RedisUrl                       string      `env:"REDIS_URL"                       flag:"redis-url"`
+	RedisPoolSize                  int         `env:"REDIS_POOL_SIZE"                 flag:"redis_pool_size"`
+	CacheExpiryInSeconds           int64       `env:"CACHE_EXPIRY_IN_SECONDS"         flag:"cache-expiry-in-seconds"`
+	CacheMaxEntries                int64       `env:"CACHE_MAX_ENTRIES"               flag:"cache-max-entries"`
+	CachePruneIntervalInSeconds    int         `env:"CACHE_PRUNE_INTERVAL_IN_SECONDS" flag:"cache-prune-interval-in-seconds"`
+	CacheReadPageSize              int         `env:"CACHE_READ_PAGE_SIZE"            flag:"cache-read-page-size"`
+	StreamFilingsPath              string      `env:"STREAM_BACKEND_FILINGS_PATH"     flag:"stream-backend-filings-path"`
+	StreamCompaniesPath            string      `env:"STREAM_BACKEND_COMPANIES_PATH"   flag:"stream-backend-companies-path"`
+	StreamInsolvencyPath           string      `env:"STREAM_BACKEND_INSOLVENCY_PATH"  flag:"stream-backend-insolvency-path"`
+	StreamChargesPath              string      `env:"STREAM_BACKEND_CHARGES_PATH"     flag:"stream-backend-charges-path"`
+	StreamOfficersPath             string      `env:"STREAM_BACKEND_OFFICERS_PATH"    flag:"stream-backend-officers-path"`
+	StreamPSCsPath                 string      `env:"STREAM_BACKEND_PSCS_PATH"        flag:"stream-backend-pscs-path"`
+	BackendRetryInMillis           int         `env:"BACKEND_RETRY_IN_MILLIS"         flag:"backend-retry-in-millis"`
+	BackendMaxRetryInMillis        int         `env:"BACKEND_MAX_RETRY_IN_MILLIS"     flag:"backend-max-retry-in-millis"`
+	BackendRetryJitterPercent      int         `env:"BACKEND_RETRY_JITTER_PERCENT"    flag:"backend-retry-jitter-percent"`
+	BackendIdleTimeoutInSeconds    int         `env:"BACKEND_IDLE_TIMEOUT_IN_SECONDS" flag:"backend-idle-timeout-in-seconds"`
+	ShutdownTimeoutInSeconds       int         `env:"SHUTDOWN_TIMEOUT_IN_SECONDS"     flag:"shutdown-timeout-in-seconds"`
+	SubscriberBufferSize           int         `env:"SUBSCRIBER_BUFFER_SIZE"          flag:"subscriber-buffer-size"`
+	SubscriberOverflowPolicy       string      `env:"SUBSCRIBER_OVERFLOW_POLICY"      flag:"subscriber-overflow-policy"`
+	SubscriberBlockTimeoutInMillis int         `env:"SUBSCRIBER_BLOCK_TIMEOUT_IN_MILLIS" flag:"subscriber-block-timeout-in-millis"`
+	StalenessWindowInSeconds       int         `env:"STALENESS_WINDOW_IN_SECONDS"        flag:"staleness-window-in-seconds"`
+	HeartbeatIntervalInSeconds     int         `env:"HEARTBEAT_INTERVAL_IN_SECONDS"      flag:"heartbeat-interval-in-seconds"`
+	ApiKeysFile                    string      `env:"API_KEYS_FILE"                      flag:"api-keys-file"`
+	MaxStreamsPerConsumer          int         `env:"MAX_STREAMS_PER_CONSUMER"           flag:"max-streams-per-consumer"`
+	ReplaysPerMinutePerConsumer    int         `env:"REPLAYS_PER_MINUTE_PER_CONSUMER"    flag:"replays-per-minute-per-consumer"`
+	ReplayBurstPerConsumer         int         `env:"REPLAY_BURST_PER_CONSUMER"          flag:"replay-burst-per-consumer"`
+	TopicsFile                     string      `env:"TOPICS_FILE"                        flag:"topics-file"`
+	CacheBackend                   string      `env:"CACHE_BACKEND"                      flag:"cache-backend"`
+	CacheHotMaxEntries             int64       `env:"CACHE_HOT_MAX_ENTRIES"              flag:"cache-hot-max-entries"`
+	TrustForwardedFor              bool        `env:"TRUST_FORWARDED_FOR"                flag:"trust-forwarded-for"`
 }
 
 // ServiceConfig returns a ServiceConfig interface for Config.
@@ -46,11 +67,20 @@ func (cfg ServiceConfig) Namespace() string {
 	return "chs-streaming-api-cache"
 }
 
+// Defaults for the settings for which zero is meaningful, so cannot stand for a setting left unset.
+const (
+	defaultBackendRetryJitterPercent   = 20
+	defaultBackendIdleTimeoutInSeconds = 120
+)
+
 var config *Config
 
 func Get() (*Config, error) {
 	if config == nil {
-		config = &Config{}
+		config = &Config{
+			BackendRetryJitterPercent:   defaultBackendRetryJitterPercent,
+			BackendIdleTimeoutInSeconds: defaultBackendIdleTimeoutInSeconds,
+		}
 		if err := gofigure.Gofigure(config); err != nil {
 			return nil, err
 		}
@@ -14,38 +14,80 @@ import (
 
 // key constants
 const (
-	BINDADDRCONST             = `BIND_ADDRESS`
-	CERTFILECONST             = `CERT_FILE`
-	KEYFILECONST              = `KEY_FILE`
-	CHSAPIKEYCONST            = `CHS_API_KEY`
-	BACKENDURLCONST           = `STREAMING_BACKEND_URL`
-	REDISURLCONST             = `REDIS_URL`
-	REDISPOOLSIZECONST        = `REDIS_POOL_SIZE`
-	CACHEEXPIRYINSECONDSCONST = `CACHE_EXPIRY_IN_SECONDS`
-	STREAMFILINGSPATHCONST    = `STREAM_BACKEND_FILINGS_PATH`
-	STREAMCOMPANIESPATHCONST  = `STREAM_BACKEND_COMPANIES_PATH`
-	STREAMINSOLVENCYPATHCONST = `STREAM_BACKEND_INSOLVENCY_PATH`
-	STREAMCHARGESPATHCONST    = `STREAM_BACKEND_CHARGES_PATH`
-	STREAMOFFICERSPATHCONST   = `STREAM_BACKEND_OFFICERS_PATH`
-	STREAMPSCSPATHCONST       = `STREAM_BACKEND_PSCS_PATH`
+	BINDADDRCONST                       = `BIND_ADDRESS`
+	CERTFILECONST                       = `CERT_FILE`
+	KEYFILECONST                        = `KEY_FILE`
+	CHSAPIKEYCONST                      = `CHS_API_KEY`
+	BACKENDURLCONST                     = `STREAMING_BACKEND_URL`
+	REDISURLCONST                       = `REDIS_URL`
+	REDISPOOLSIZECONST                  = `REDIS_POOL_SIZE`
+	CACHEEXPIRYINSECONDSCONST           = `CACHE_EXPIRY_IN_SECONDS`
+	STREAMFILINGSPATHCONST              = `STREAM_BACKEND_FILINGS_PATH`
+	STREAMCOMPANIESPATHCONST            = `STREAM_BACKEND_COMPANIES_PATH`
+	STREAMINSOLVENCYPATHCONST           = `STREAM_BACKEND_INSOLVENCY_PATH`
+	STREAMCHARGESPATHCONST              = `STREAM_BACKEND_CHARGES_PATH`
+	STREAMOFFICERSPATHCONST             = `STREAM_BACKEND_OFFICERS_PATH`
+	STREAMPSCSPATHCONST                 = `STREAM_BACKEND_PSCS_PATH`
+	BACKENDRETRYINMILLISCONST           = `BACKEND_RETRY_IN_MILLIS`
+	BACKENDMAXRETRYINMILLISCONST        = `BACKEND_MAX_RETRY_IN_MILLIS`
+	BACKENDRETRYJITTERPERCENTCONST      = `BACKEND_RETRY_JITTER_PERCENT`
+	BACKENDIDLETIMEOUTINSECONDSCONST    = `BACKEND_IDLE_TIMEOUT_IN_SECONDS`
+	SHUTDOWNTIMEOUTINSECONDSCONST       = `SHUTDOWN_TIMEOUT_IN_SECONDS`
+	SUBSCRIBERBUFFERSIZECONST           = `SUBSCRIBER_BUFFER_SIZE`
+	SUBSCRIBEROVERFLOWPOLICYCONST       = `SUBSCRIBER_OVERFLOW_POLICY`
+	SUBSCRIBERBLOCKTIMEOUTINMILLISCONST = `SUBSCRIBER_BLOCK_TIMEOUT_IN_MILLIS`
+	STALENESSWINDOWINSECONDSCONST       = `STALENESS_WINDOW_IN_SECONDS`
+	CACHEMAXENTRIESCONST                = `CACHE_MAX_ENTRIES`
+	CACHEPRUNEINTERVALINSECONDSCONST    = `CACHE_PRUNE_INTERVAL_IN_SECONDS`
+	CACHEREADPAGESIZECONST              = `CACHE_READ_PAGE_SIZE`
+	HEARTBEATINTERVALINSECONDSCONST     = `HEARTBEAT_INTERVAL_IN_SECONDS`
+	APIKEYSFILECONST                    = `API_KEYS_FILE`
+	MAXSTREAMSPERCONSUMERCONST          = `MAX_STREAMS_PER_CONSUMER`
+	REPLAYSPERMINUTEPERCONSUMERCONST    = `REPLAYS_PER_MINUTE_PER_CONSUMER`
+	REPLAYBURSTPERCONSUMERCONST         = `REPLAY_BURST_PER_CONSUMER`
+	TOPICSFILECONST                     = `TOPICS_FILE`
+	CACHEBACKENDCONST                   = `CACHE_BACKEND`
+	CACHEHOTMAXENTRIESCONST             = `CACHE_HOT_MAX_ENTRIES`
+	TRUSTFORWARDEDFORCONST              = `TRUST_FORWARDED_FOR`
 )
 
 // value constants
 const (
-	bindAddrConst             = `bind-addr`
-	certFileConst             = `cert-file`
-	keyFileConst              = `key-file`
-	chsApiKeyConst            = `chs-api-key`
-	backEndUrlConst           = `streaming-backend-url`
-	redisUrlConst             = `redis-url`
-	redisPoolSizeConst        = 123
-	cacheExpiryInSecondsConst = 456
-	streamFilingsPathConst    = `stream-backend-filings-path`
-	streamCompaniesPathConst  = `stream-backend-companies-path`
-	streamInsolvencyPathConst = `stream-backend-insolvency-path`
-	streamChargesPathConst    = `stream-backend-charges-path`
-	streamOfficersPathConst   = `stream-backend-officers-path`
-	streamPSCsPathConst       = `stream-backend-pscs-path`
+	bindAddrConst                       = `bind-addr`
+	certFileConst                       = `cert-file`
+	keyFileConst                        = `key-file`
+	chsApiKeyConst                      = `chs-api-key`
+	backEndUrlConst                     = `streaming-backend-url`
+	redisUrlConst                       = `redis-url`
+	redisPoolSizeConst                  = 123
+	cacheExpiryInSecondsConst           = 456
+	streamFilingsPathConst              = `stream-backend-filings-path`
+	streamCompaniesPathConst            = `stream-backend-companies-path`
+	streamInsolvencyPathConst           = `stream-backend-insolvency-path`
+	streamChargesPathConst              = `stream-backend-charges-path`
+	streamOfficersPathConst             = `stream-backend-officers-path`
+	streamPSCsPathConst                 = `stream-backend-pscs-path`
+	backendRetryInMillisConst           = 1001
+	backendMaxRetryInMillisConst        = 30002
+	backendRetryJitterPercentConst      = 21
+	backendIdleTimeoutInSecondsConst    = 91
+	shutdownTimeoutInSecondsConst       = 17
+	subscriberBufferSizeConst           = 57
+	subscriberOverflowPolicyConst       = `subscriber-overflow-policy`
+	subscriberBlockTimeoutInMillisConst = 2503
+	stalenessWindowInSecondsConst       = 301
+	cacheMaxEntriesConst                = 5003
+	cachePruneIntervalInSecondsConst    = 67
+	cacheReadPageSizeConst              = 251
+	heartbeatIntervalInSecondsConst     = 29
+	apiKeysFileConst                    = `/etc/keys/api-keys.json`
+	maxStreamsPerConsumerConst          = 7
+	replaysPerMinutePerConsumerConst    = 13
+	replayBurstPerConsumerConst         = 3
+	topicsFileConst                     = `/etc/topics/topics.yaml`
+	cacheBackendConst                   = `memory`
+	cacheHotMaxEntriesConst             = 2500
+	trustForwardedForConst              = true
 )
 
 func TestConfig(t *testing.T) {
@@ -55,51 +97,114 @@ func TestConfig(t *testing.T) {
 		err           error
 		configuration *config.Config
 		envVars       = map[string]string{
-			BINDADDRCONST:             bindAddrConst,
-			CERTFILECONST:             certFileConst,
-			KEYFILECONST:              keyFileConst,
-			CHSAPIKEYCONST:            chsApiKeyConst,
-			BACKENDURLCONST:           backEndUrlConst,
-			REDISURLCONST:             redisUrlConst,
-			REDISPOOLSIZECONST:        strconv.Itoa(redisPoolSizeConst),
-			CACHEEXPIRYINSECONDSCONST: strconv.Itoa(cacheExpiryInSecondsConst),
-			STREAMFILINGSPATHCONST:    streamFilingsPathConst,
-			STREAMCOMPANIESPATHCONST:  streamCompaniesPathConst,
-			STREAMINSOLVENCYPATHCONST: streamInsolvencyPathConst,
-			STREAMCHARGESPATHCONST:    streamChargesPathConst,
-			STREAMOFFICERSPATHCONST:   streamOfficersPathConst,
-			STREAMPSCSPATHCONST:       streamPSCsPathConst,
+			BINDADDRCONST:                       bindAddrConst,
+			CERTFILECONST:                       certFileConst,
+			KEYFILECONST:                        keyFileConst,
+			CHSAPIKEYCONST:                      chsApiKeyConst,
+			BACKENDURLCONST:                     backEndUrlConst,
+			REDISURLCONST:                       redisUrlConst,
+			REDISPOOLSIZECONST:                  strconv.Itoa(redisPoolSizeConst),
+			CACHEEXPIRYINSECONDSCONST:           strconv.Itoa(cacheExpiryInSecondsConst),
+			STREAMFILINGSPATHCONST:              streamFilingsPathConst,
+			STREAMCOMPANIESPATHCONST:            streamCompaniesPathConst,
+			STREAMINSOLVENCYPATHCONST:           streamInsolvencyPathConst,
+			STREAMCHARGESPATHCONST:              streamChargesPathConst,
+			STREAMOFFICERSPATHCONST:             streamOfficersPathConst,
+			STREAMPSCSPATHCONST:                 streamPSCsPathConst,
+			BACKENDRETRYINMILLISCONST:           strconv.Itoa(backendRetryInMillisConst),
+			BACKENDMAXRETRYINMILLISCONST:        strconv.Itoa(backendMaxRetryInMillisConst),
+			BACKENDRETRYJITTERPERCENTCONST:      strconv.Itoa(backendRetryJitterPercentConst),
+			BACKENDIDLETIMEOUTINSECONDSCONST:    strconv.Itoa(backendIdleTimeoutInSecondsConst),
+			SHUTDOWNTIMEOUTINSECONDSCONST:       strconv.Itoa(shutdownTimeoutInSecondsConst),
+			SUBSCRIBERBUFFERSIZECONST:           strconv.Itoa(subscriberBufferSizeConst),
+			SUBSCRIBEROVERFLOWPOLICYCONST:       subscriberOverflowPolicyConst,
+			SUBSCRIBERBLOCKTIMEOUTINMILLISCONST: strconv.Itoa(subscriberBlockTimeoutInMillisConst),
+			STALENESSWINDOWINSECONDSCONST:       strconv.Itoa(stalenessWindowInSecondsConst),
+			CACHEMAXENTRIESCONST:                strconv.Itoa(cacheMaxEntriesConst),
+			CACHEPRUNEINTERVALINSECONDSCONST:    strconv.Itoa(cachePruneIntervalInSecondsConst),
+			CACHEREADPAGESIZECONST:              strconv.Itoa(cacheReadPageSizeConst),
+			HEARTBEATINTERVALINSECONDSCONST:     strconv.Itoa(heartbeatIntervalInSecondsConst),
+			APIKEYSFILECONST:                    apiKeysFileConst,
+			MAXSTREAMSPERCONSUMERCONST:          strconv.Itoa(maxStreamsPerConsumerConst),
+			REPLAYSPERMINUTEPERCONSUMERCONST:    strconv.Itoa(replaysPerMinutePerConsumerConst),
+			REPLAYBURSTPERCONSUMERCONST:         strconv.Itoa(replayBurstPerConsumerConst),
+			TOPICSFILECONST:                     topicsFileConst,
+			CACHEBACKENDCONST:                   cacheBackendConst,
+			CACHEHOTMAXENTRIESCONST:             strconv.Itoa(cacheHotMaxEntriesConst),
+			TRUSTFORWARDEDFORCONST:              strconv.FormatBool(trustForwardedForConst),
 		}
 		builtConfig = config.Config{
-			BindAddress:          bindAddrConst,
-			CertFile:             certFileConst,
-			KeyFile:              keyFileConst,
-			ChsApiKey:            chsApiKeyConst,
-			BackEndUrl:           backEndUrlConst,
-			RedisUrl:             redisUrlConst,
-			RedisPoolSize:        redisPoolSizeConst,
-			CacheExpiryInSeconds: cacheExpiryInSecondsConst,
-			StreamFilingsPath:    streamFilingsPathConst,
-			StreamCompaniesPath:  streamCompaniesPathConst,
-			StreamInsolvencyPath: streamInsolvencyPathConst,
-			StreamChargesPath:    streamChargesPathConst,
-			StreamOfficersPath:   streamOfficersPathConst,
-			StreamPSCsPath:       streamPSCsPathConst,
+			BindAddress:                    bindAddrConst,
+			CertFile:                       certFileConst,
+			KeyFile:                        keyFileConst,
+			ChsApiKey:                      chsApiKeyConst,
+			BackEndUrl:                     backEndUrlConst,
+			RedisUrl:                       redisUrlConst,
+			RedisPoolSize:                  redisPoolSizeConst,
+			CacheExpiryInSeconds:           cacheExpiryInSecondsConst,
+			StreamFilingsPath:              streamFilingsPathConst,
+			StreamCompaniesPath:            streamCompaniesPathConst,
+			StreamInsolvencyPath:           streamInsolvencyPathConst,
+			StreamChargesPath:              streamChargesPathConst,
+			StreamOfficersPath:             streamOfficersPathConst,
+			StreamPSCsPath:                 streamPSCsPathConst,
+			BackendRetryInMillis:           backendRetryInMillisConst,
+			BackendMaxRetryInMillis:        backendMaxRetryInMillisConst,
+			BackendRetryJitterPercent:      backendRetryJitterPercentConst,
+			BackendIdleTimeoutInSeconds:    backendIdleTimeoutInSecondsConst,
+			ShutdownTimeoutInSeconds:       shutdownTimeoutInSecondsConst,
+			SubscriberBufferSize:           subscriberBufferSizeConst,
+			SubscriberOverflowPolicy:       subscriberOverflowPolicyConst,
+			SubscriberBlockTimeoutInMillis: subscriberBlockTimeoutInMillisConst,
+			StalenessWindowInSeconds:       stalenessWindowInSecondsConst,
+			CacheMaxEntries:                cacheMaxEntriesConst,
+			CachePruneIntervalInSeconds:    cachePruneIntervalInSecondsConst,
+			CacheReadPageSize:              cacheReadPageSizeConst,
+			HeartbeatIntervalInSeconds:     heartbeatIntervalInSecondsConst,
+			ApiKeysFile:                    apiKeysFileConst,
+			MaxStreamsPerConsumer:          maxStreamsPerConsumerConst,
+			ReplaysPerMinutePerConsumer:    replaysPerMinutePerConsumerConst,
+			ReplayBurstPerConsumer:         replayBurstPerConsumerConst,
+			TopicsFile:                     topicsFileConst,
+			CacheBackend:                   cacheBackendConst,
+			CacheHotMaxEntries:             cacheHotMaxEntriesConst,
+			TrustForwardedFor:              trustForwardedForConst,
 		}
-		bindAddrRegex             = regexp.MustCompile(bindAddrConst)
-		certFileRegex             = regexp.MustCompile(certFileConst)
-		keyFileRegex              = regexp.MustCompile(keyFileConst)
-		chsApiKeyRegex            = regexp.MustCompile(chsApiKeyConst)
-		backEndUrlRegex           = regexp.MustCompile(backEndUrlConst)
-		redisUrlRegex             = regexp.MustCompile(redisUrlConst)
-		redisPoolSizeRegex        = regexp.MustCompile(strconv.Itoa(redisPoolSizeConst))
-		cacheExpiryInSecondsRegex = regexp.MustCompile(strconv.Itoa(cacheExpiryInSecondsConst))
-		streamFilingsPathRegex    = regexp.MustCompile(streamFilingsPathConst)
-		streamCompaniesPathRegex  = regexp.MustCompile(streamCompaniesPathConst)
-		streamInsolvencyPathRegex = regexp.MustCompile(streamInsolvencyPathConst)
-		streamChargesPathRegex    = regexp.MustCompile(streamChargesPathConst)
-		streamOfficersPathRegex   = regexp.MustCompile(streamOfficersPathConst)
-		streamPSCsPathRegex       = regexp.MustCompile(streamPSCsPathConst)
+		bindAddrRegex                       = regexp.MustCompile(bindAddrConst)
+		certFileRegex                       = regexp.MustCompile(certFileConst)
+		keyFileRegex                        = regexp.MustCompile(keyFileConst)
+		chsApiKeyRegex                      = regexp.MustCompile(chsApiKeyConst)
+		backEndUrlRegex                     = regexp.MustCompile(backEndUrlConst)
+		redisUrlRegex                       = regexp.MustCompile(redisUrlConst)
+		redisPoolSizeRegex                  = regexp.MustCompile(strconv.Itoa(redisPoolSizeConst))
+		cacheExpiryInSecondsRegex           = regexp.MustCompile(strconv.Itoa(cacheExpiryInSecondsConst))
+		streamFilingsPathRegex              = regexp.MustCompile(streamFilingsPathConst)
+		streamCompaniesPathRegex            = regexp.MustCompile(streamCompaniesPathConst)
+		streamInsolvencyPathRegex           = regexp.MustCompile(streamInsolvencyPathConst)
+		streamChargesPathRegex              = regexp.MustCompile(streamChargesPathConst)
+		streamOfficersPathRegex             = regexp.MustCompile(streamOfficersPathConst)
+		streamPSCsPathRegex                 = regexp.MustCompile(streamPSCsPathConst)
+		backendRetryInMillisRegex           = regexp.MustCompile(strconv.Itoa(backendRetryInMillisConst))
+		backendMaxRetryInMillisRegex        = regexp.MustCompile(strconv.Itoa(backendMaxRetryInMillisConst))
+		backendRetryJitterPercentRegex      = regexp.MustCompile(strconv.Itoa(backendRetryJitterPercentConst))
+		backendIdleTimeoutInSecondsRegex    = regexp.MustCompile(strconv.Itoa(backendIdleTimeoutInSecondsConst))
+		shutdownTimeoutInSecondsRegex       = regexp.MustCompile(strconv.Itoa(shutdownTimeoutInSecondsConst))
+		subscriberBufferSizeRegex           = regexp.MustCompile(strconv.Itoa(subscriberBufferSizeConst))
+		subscriberOverflowPolicyRegex       = regexp.MustCompile(subscriberOverflowPolicyConst)
+		subscriberBlockTimeoutInMillisRegex = regexp.MustCompile(strconv.Itoa(subscriberBlockTimeoutInMillisConst))
+		stalenessWindowInSecondsRegex       = regexp.MustCompile(strconv.Itoa(stalenessWindowInSecondsConst))
+		cacheMaxEntriesRegex                = regexp.MustCompile(strconv.Itoa(cacheMaxEntriesConst))
+		cachePruneIntervalInSecondsRegex    = regexp.MustCompile(strconv.Itoa(cachePruneIntervalInSecondsConst))
+		cacheReadPageSizeRegex              = regexp.MustCompile(strconv.Itoa(cacheReadPageSizeConst))
+		heartbeatIntervalInSecondsRegex     = regexp.MustCompile(strconv.Itoa(heartbeatIntervalInSecondsConst))
+		apiKeysFileRegex                    = regexp.MustCompile(apiKeysFileConst)
+		maxStreamsPerConsumerRegex          = regexp.MustCompile(strconv.Itoa(maxStreamsPerConsumerConst))
+		replaysPerMinutePerConsumerRegex    = regexp.MustCompile(strconv.Itoa(replaysPerMinutePerConsumerConst))
+		replayBurstPerConsumerRegex         = regexp.MustCompile(strconv.Itoa(replayBurstPerConsumerConst))
+		topicsFileRegex                     = regexp.MustCompile(topicsFileConst)
+		cacheBackendRegex                   = regexp.MustCompile(cacheBackendConst)
+		cacheHotMaxEntriesRegex             = regexp.MustCompile(strconv.Itoa(cacheHotMaxEntriesConst))
+		trustForwardedForRegex              = regexp.MustCompile(`"TrustForwardedFor":` + strconv.FormatBool(trustForwardedForConst))
 	)
 
 	// set test env variables
@@ -141,6 +246,27 @@ func TestConfig(t *testing.T) {
 				So(streamChargesPathRegex.Match(jsonByte), ShouldEqual, true)
 				So(streamOfficersPathRegex.Match(jsonByte), ShouldEqual, true)
 				So(streamPSCsPathRegex.Match(jsonByte), ShouldEqual, true)
+				So(backendRetryInMillisRegex.Match(jsonByte), ShouldEqual, true)
+				So(backendMaxRetryInMillisRegex.Match(jsonByte), ShouldEqual, true)
+				So(backendRetryJitterPercentRegex.Match(jsonByte), ShouldEqual, true)
+				So(backendIdleTimeoutInSecondsRegex.Match(jsonByte), ShouldEqual, true)
+				So(shutdownTimeoutInSecondsRegex.Match(jsonByte), ShouldEqual, true)
+				So(subscriberBufferSizeRegex.Match(jsonByte), ShouldEqual, true)
+				So(subscriberOverflowPolicyRegex.Match(jsonByte), ShouldEqual, true)
+				So(subscriberBlockTimeoutInMillisRegex.Match(jsonByte), ShouldEqual, true)
+				So(stalenessWindowInSecondsRegex.Match(jsonByte), ShouldEqual, true)
+				So(cacheMaxEntriesRegex.Match(jsonByte), ShouldEqual, true)
+				So(cachePruneIntervalInSecondsRegex.Match(jsonByte), ShouldEqual, true)
+				So(cacheReadPageSizeRegex.Match(jsonByte), ShouldEqual, true)
+				So(heartbeatIntervalInSecondsRegex.Match(jsonByte), ShouldEqual, true)
+				So(apiKeysFileRegex.Match(jsonByte), ShouldEqual, true)
+				So(maxStreamsPerConsumerRegex.Match(jsonByte), ShouldEqual, true)
+				So(replaysPerMinutePerConsumerRegex.Match(jsonByte), ShouldEqual, true)
+				So(replayBurstPerConsumerRegex.Match(jsonByte), ShouldEqual, true)
+				So(topicsFileRegex.Match(jsonByte), ShouldEqual, true)
+				So(cacheBackendRegex.Match(jsonByte), ShouldEqual, true)
+				So(cacheHotMaxEntriesRegex.Match(jsonByte), ShouldEqual, true)
+				So(trustForwardedForRegex.Match(jsonByte), ShouldEqual, true)
 			})
 		})
 	})
@@ -0,0 +1,48 @@
+// Package delta extracts the attributes of the deltas streamed to users.
+package delta
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Attributes of a delta by which the deltas streamed to users can be filtered.
+type Attributes struct {
+	ResourceKind  string
+	CompanyNumber string
+}
+
+// The parts of a delta holding its attributes.
+type document struct {
+	ResourceKind string `json:"resource_kind"`
+	ResourceURI  string `json:"resource_uri"`
+	Data         struct {
+		CompanyNumber string `json:"company_number"`
+	} `json:"data"`
+}
+
+// Parse the attributes of a delta. The company number is taken from the resource URI, falling back
+// to the company number in the data of the delta. A delta that cannot be parsed has no attributes.
+func Parse(data string) Attributes {
+	doc := &document{}
+	if err := json.Unmarshal([]byte(data), doc); err != nil {
+		return Attributes{}
+	}
+	attributes := Attributes{
+		ResourceKind:  doc.ResourceKind,
+		CompanyNumber: companyNumberFromURI(doc.ResourceURI),
+	}
+	if attributes.CompanyNumber == "" {
+		attributes.CompanyNumber = doc.Data.CompanyNumber
+	}
+	return attributes
+}
+
+// Obtain the company number from a resource URI such as /company/00000001/filing-history/abc.
+func companyNumberFromURI(uri string) string {
+	parts := strings.Split(strings.TrimPrefix(uri, "/"), "/")
+	if len(parts) < 2 || parts[0] != "company" {
+		return ""
+	}
+	return parts[1]
+}
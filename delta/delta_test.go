@@ -0,0 +1,29 @@
+package delta
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestUnitParse(t *testing.T) {
+	Convey("Given deltas for different resources", t, func() {
+		Convey("When a delta with a company resource URI is parsed", func() {
+			actual := Parse(`{"resource_kind":"filing-history","resource_uri":"/company/00000001/filing-history/abc","data":{"company_number":"00000002"}}`)
+			Convey("Then the company number should be taken from the URI", func() {
+				So(actual, ShouldResemble, Attributes{ResourceKind: "filing-history", CompanyNumber: "00000001"})
+			})
+		})
+		Convey("When a delta without a company resource URI is parsed", func() {
+			actual := Parse(`{"resource_kind":"company-profile","resource_uri":"/other","data":{"company_number":"00000002"}}`)
+			Convey("Then the company number should be taken from the data", func() {
+				So(actual, ShouldResemble, Attributes{ResourceKind: "company-profile", CompanyNumber: "00000002"})
+			})
+		})
+		Convey("When a delta that is not JSON is parsed", func() {
+			actual := Parse("Hello world")
+			Convey("Then it should have no attributes", func() {
+				So(actual, ShouldResemble, Attributes{})
+			})
+		})
+	})
+}
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/delta"
+	"net/url"
+	"strings"
+)
+
+// A filter of the deltas streamed to a user, requested by the company_number and resource_kind
+// query parameters. Each parameter may be repeated or hold a comma separated list of values.
+type filter struct {
+	companyNumbers map[string]bool
+	resourceKinds  map[string]bool
+}
+
+// Create the filter requested in a query, or nil if no filter was requested.
+func newFilter(query url.Values) *filter {
+	f := &filter{
+		companyNumbers: values(query, "company_number", strings.ToUpper),
+		resourceKinds:  values(query, "resource_kind", strings.ToLower),
+	}
+	if f.companyNumbers == nil && f.resourceKinds == nil {
+		return nil
+	}
+	return f
+}
+
+// Collect the normalised values of a query parameter, or nil if it was not given.
+func values(query url.Values, key string, normalise func(string) string) map[string]bool {
+	var set map[string]bool
+	for _, value := range query[key] {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v == "" {
+				continue
+			}
+			if set == nil {
+				set = make(map[string]bool)
+			}
+			set[normalise(v)] = true
+		}
+	}
+	return set
+}
+
+// Report whether a delta with the given attributes should be streamed to the user.
+func (f *filter) matches(attributes delta.Attributes) bool {
+	if f == nil {
+		return true
+	}
+	if f.companyNumbers != nil && !f.companyNumbers[strings.ToUpper(attributes.CompanyNumber)] {
+		return false
+	}
+	if f.resourceKinds != nil && !f.resourceKinds[strings.ToLower(attributes.ResourceKind)] {
+		return false
+	}
+	return true
+}
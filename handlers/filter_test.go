@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/delta"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/url"
+	"testing"
+)
+
+func TestFilterMatchesRequestedCompaniesAndResourceKinds(t *testing.T) {
+	Convey("Given a filter for two companies and one resource kind", t, func() {
+		f := newFilter(url.Values{"company_number": {"00000001,oc000002"}, "resource_kind": {"Filing-History"}})
+		Convey("Then only deltas matching both should be streamed", func() {
+			So(f.matches(delta.Attributes{CompanyNumber: "00000001", ResourceKind: "filing-history"}), ShouldBeTrue)
+			So(f.matches(delta.Attributes{CompanyNumber: "OC000002", ResourceKind: "filing-history"}), ShouldBeTrue)
+			So(f.matches(delta.Attributes{CompanyNumber: "00000003", ResourceKind: "filing-history"}), ShouldBeFalse)
+			So(f.matches(delta.Attributes{CompanyNumber: "00000001", ResourceKind: "company-charges"}), ShouldBeFalse)
+		})
+	})
+	Convey("Given a query without any filter", t, func() {
+		f := newFilter(url.Values{"timepoint": {"1"}})
+		Convey("Then no filter should be created and every delta should be streamed", func() {
+			So(f, ShouldBeNil)
+			So(f.matches(delta.Attributes{}), ShouldBeTrue)
+		})
+	})
+}
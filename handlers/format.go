@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// A Format frames the deltas written to a user.
+type Format interface {
+	// The name by which users can request this format in the format query parameter.
+	Name() string
+	// The content type of the response, by which users can request this format in the Accept header.
+	ContentType() string
+	// Write whatever begins a stream of live deltas when no cached offsets are replayed first.
+	Begin(w io.Writer) error
+	// Write a delta along with the offset it was cached at.
+	WriteDelta(w io.Writer, offset int64, data string) error
+	// Write the marker telling the user the stream has been closed by the server.
+	WriteEndOfStream(w io.Writer) error
+	// Write a heartbeat, telling the user the stream is still live when no deltas have been written.
+	WriteHeartbeat(w io.Writer) error
+}
+
+// The formats users can request; the first is served if they do not ask for one.
+var formats = []Format{NDJSONFormat{}, EventStreamFormat{}, BinaryFormat{}}
+
+// Choose the format to stream to a user, by the format query parameter if given or otherwise the
+// supported content type in the Accept header with the highest quality, the first listed if several
+// are equally preferred. Content types given a quality of zero are not chosen, even by default.
+func negotiateFormat(request *http.Request) (Format, error) {
+	if name := request.URL.Query().Get("format"); name != "" {
+		for _, format := range formats {
+			if format.Name() == name {
+				return format, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown format [%s]", name)
+	}
+	var chosen Format
+	best := 0.0
+	refused := make(map[string]bool)
+	for _, accept := range strings.Split(request.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			if quality, err = strconv.ParseFloat(q, 64); err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			refused[mediaType] = true
+			continue
+		}
+		for _, format := range formats {
+			if format.ContentType() == mediaType && quality > best {
+				chosen, best = format, quality
+			}
+		}
+	}
+	if chosen != nil {
+		return chosen, nil
+	}
+	for _, format := range formats {
+		if !refused[format.ContentType()] {
+			return format, nil
+		}
+	}
+	return formats[0], nil
+}
+
+// NDJSONFormat writes each delta on its own line.
+type NDJSONFormat struct{}
+
+func (f NDJSONFormat) Name() string {
+	return "ndjson"
+}
+
+func (f NDJSONFormat) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (f NDJSONFormat) Begin(w io.Writer) error {
+	_, err := w.Write([]byte("\n"))
+	return err
+}
+
+func (f NDJSONFormat) WriteDelta(w io.Writer, offset int64, data string) error {
+	_, err := w.Write([]byte(data + "\n"))
+	return err
+}
+
+func (f NDJSONFormat) WriteEndOfStream(w io.Writer) error {
+	_, err := w.Write([]byte(endOfStream + "\n"))
+	return err
+}
+
+func (f NDJSONFormat) WriteHeartbeat(w io.Writer) error {
+	_, err := w.Write([]byte("\n"))
+	return err
+}
+
+// EventStreamFormat writes each delta as a server-sent event, identified by its offset so that a
+// reconnecting user resumes from it by sending the Last-Event-ID header.
+type EventStreamFormat struct{}
+
+func (f EventStreamFormat) Name() string {
+	return "sse"
+}
+
+func (f EventStreamFormat) ContentType() string {
+	return "text/event-stream"
+}
+
+func (f EventStreamFormat) Begin(w io.Writer) error {
+	return nil
+}
+
+func (f EventStreamFormat) WriteDelta(w io.Writer, offset int64, data string) error {
+	return f.writeEvent(w, "", offset, data)
+}
+
+func (f EventStreamFormat) WriteEndOfStream(w io.Writer) error {
+	return f.writeEvent(w, "end_of_stream", 0, endOfStream)
+}
+
+func (f EventStreamFormat) WriteHeartbeat(w io.Writer) error {
+	_, err := w.Write([]byte(": heartbeat\n\n"))
+	return err
+}
+
+func (f EventStreamFormat) writeEvent(w io.Writer, event string, offset int64, data string) error {
+	var b bytes.Buffer
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	if offset > 0 {
+		b.WriteString("id: " + strconv.FormatInt(offset, 10) + "\n")
+	}
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// BinaryFormat writes each delta as a frame made up of a 4 byte big-endian length of the rest of
+// the frame, an 8 byte big-endian offset, and the delta itself. The end of the stream is marked by
+// a frame with an offset of zero, and a heartbeat by an empty frame with a length of zero.
+type BinaryFormat struct{}
+
+func (f BinaryFormat) Name() string {
+	return "binary"
+}
+
+func (f BinaryFormat) ContentType() string {
+	return "application/octet-stream"
+}
+
+func (f BinaryFormat) Begin(w io.Writer) error {
+	return nil
+}
+
+func (f BinaryFormat) WriteDelta(w io.Writer, offset int64, data string) error {
+	frame := make([]byte, 12, 12+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(8+len(data)))
+	binary.BigEndian.PutUint64(frame[4:], uint64(offset))
+	_, err := w.Write(append(frame, data...))
+	return err
+}
+
+func (f BinaryFormat) WriteEndOfStream(w io.Writer) error {
+	return f.WriteDelta(w, 0, endOfStream)
+}
+
+func (f BinaryFormat) WriteHeartbeat(w io.Writer) error {
+	_, err := w.Write(make([]byte, 4))
+	return err
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	Convey("Given requests for different formats", t, func() {
+		Convey("When the format query parameter is given", func() {
+			request := httptest.NewRequest("GET", "/endpoint?format=binary", nil)
+			request.Header.Set("Accept", "text/event-stream")
+			format, err := negotiateFormat(request)
+			Convey("Then it should be preferred to the Accept header", func() {
+				So(err, ShouldBeNil)
+				So(format, ShouldResemble, BinaryFormat{})
+			})
+		})
+		Convey("When the Accept header lists several content types", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil)
+			request.Header.Set("Accept", "application/json, text/event-stream;q=0.9, application/x-ndjson;q=0.8")
+			format, err := negotiateFormat(request)
+			Convey("Then the first supported content type should be chosen", func() {
+				So(err, ShouldBeNil)
+				So(format, ShouldResemble, EventStreamFormat{})
+			})
+		})
+		Convey("When the Accept header gives content types different qualities", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil)
+			request.Header.Set("Accept", "text/event-stream;q=0.5, application/octet-stream;q=0.9")
+			format, err := negotiateFormat(request)
+			Convey("Then the supported content type of the highest quality should be chosen", func() {
+				So(err, ShouldBeNil)
+				So(format, ShouldResemble, BinaryFormat{})
+			})
+		})
+		Convey("When the Accept header refuses a content type with a quality of zero", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil)
+			request.Header.Set("Accept", "text/event-stream;q=0, application/x-ndjson")
+			format, err := negotiateFormat(request)
+			Convey("Then it should not be chosen", func() {
+				So(err, ShouldBeNil)
+				So(format, ShouldResemble, NDJSONFormat{})
+			})
+		})
+		Convey("When the Accept header refuses the default content type and lists no other supported one", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil)
+			request.Header.Set("Accept", "application/x-ndjson;q=0, application/json")
+			format, err := negotiateFormat(request)
+			Convey("Then the next supported content type should be chosen", func() {
+				So(err, ShouldBeNil)
+				So(format, ShouldResemble, EventStreamFormat{})
+			})
+		})
+		Convey("When no format is requested", func() {
+			format, err := negotiateFormat(httptest.NewRequest("GET", "/endpoint", nil))
+			Convey("Then newline delimited JSON should be chosen", func() {
+				So(err, ShouldBeNil)
+				So(format, ShouldResemble, NDJSONFormat{})
+			})
+		})
+		Convey("When an unknown format is requested", func() {
+			_, err := negotiateFormat(httptest.NewRequest("GET", "/endpoint?format=xml", nil))
+			Convey("Then an error should be returned", func() {
+				So(err.Error(), ShouldEqual, "unknown format [xml]")
+			})
+		})
+	})
+}
+
+func TestEventStreamFormatWritesEachLineAsData(t *testing.T) {
+	Convey("When data spanning several lines is written as an event", t, func() {
+		var b bytes.Buffer
+		err := EventStreamFormat{}.WriteDelta(&b, 7, "first\nsecond")
+		Convey("Then each line should be written as a separate data field", func() {
+			So(err, ShouldBeNil)
+			So(b.String(), ShouldEqual, "id: 7\ndata: first\ndata: second\n\n")
+		})
+	})
+}
+
+func TestBinaryFormatWritesLengthPrefixedFrames(t *testing.T) {
+	Convey("When a delta is written as a binary frame", t, func() {
+		var b bytes.Buffer
+		err := BinaryFormat{}.WriteDelta(&b, 258, "abc")
+		Convey("Then it should be prefixed with its length and offset", func() {
+			So(err, ShouldBeNil)
+			So(b.Bytes(), ShouldResemble, []byte{0, 0, 0, 11, 0, 0, 0, 0, 0, 0, 1, 2, 'a', 'b', 'c'})
+		})
+	})
+}
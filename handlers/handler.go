@@ -1,17 +1,98 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
 	. "github.com/companieshouse/chs-streaming-api-cache/cache"
+	"github.com/companieshouse/chs-streaming-api-cache/delta"
+	"github.com/companieshouse/chs-streaming-api-cache/limits"
 	"github.com/companieshouse/chs-streaming-api-cache/logger"
 	"github.com/companieshouse/chs-streaming-api-cache/offset"
+	"github.com/companieshouse/chs-streaming-api-cache/projection"
 	"github.com/companieshouse/chs.go/log"
+	"github.com/gorilla/websocket"
+	"io"
 	"net/http"
 	"sync"
+	"time"
 )
 
 type Subscribable interface {
-	Subscribe() (chan string, error)
-	Unsubscribe(chan string) error
+	Subscribe(subscriber *broker.Subscriber) (chan *broker.Message, error)
+	Unsubscribe(chan *broker.Message) error
+}
+
+// The final line written to users when the stream is closed by the server.
+const endOfStream = "{\"end_of_stream\":true}"
+
+const defaultHeartbeatInterval = 30 * time.Second
+
+// The header identifying each request, set by the request ID middleware.
+const requestIDHeader = "X-Request-Id"
+
+// The state of a single user's stream.
+type stream struct {
+	writer       io.Writer
+	flush        func()
+	subscription chan *broker.Message
+	// The user as subscribed to the broker, counting the deltas written to it
+	subscriber *broker.Subscriber
+	// Closed once the user has disconnected
+	done <-chan struct{}
+	// The offsets the user has asked to resume from while streaming, if it can
+	resume <-chan int64
+	// The format in which deltas are written
+	format Format
+	// When the user was last written to
+	written time.Time
+	// The deltas the user has asked for, or nil for all of them
+	filter *filter
+	// The fields of each delta the user has asked for, or nil for all of them
+	fields projection.Projection
+	// The consumer whose stream this is, by which its limits are tracked
+	consumer string
+	// Releases the stream from the consumer's limit once it has ended
+	release func()
+	// The highest offset streamed to the user, whether written or filtered out, or -1 if none is known
+	last int64
+	// The highest offset of the live messages discarded while replaying the cache
+	seen int64
+	// Whether the subscription was found to be closed while replaying the cache
+	closed bool
+}
+
+// Discard the live messages waiting on the subscription, recording the highest offset seen.
+func (s *stream) drain() {
+	for {
+		select {
+		case msg, ok := <-s.subscription:
+			if !ok {
+				s.closed = true
+				return
+			}
+			if msg.Offset > s.seen {
+				s.seen = msg.Offset
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Whether the user has disconnected or its subscription has been closed, such as by an admin or
+// while shutting down, so that no more of the cache should be replayed to it.
+func (s *stream) ended() bool {
+	if s.closed {
+		return true
+	}
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
 }
 
 type RequestHandler struct {
@@ -20,7 +101,14 @@ type RequestHandler struct {
 	key          string
 	logger       logger.Logger
 	offset       offset.Interface
-	wg           *sync.WaitGroup
+	mutex        sync.Mutex
+	closed       bool
+	streams      sync.WaitGroup
+	heartbeat    time.Duration
+	limiter      *limits.Limiter
+	// Whether consumers are identified by the X-Forwarded-For header, set by a proxy in front
+	trustForwardedFor bool
+	wg                *sync.WaitGroup
 }
 
 func NewRequestHandler(broker Subscribable, cacheService Cacheable, logger logger.Logger, topic string) *RequestHandler {
@@ -30,61 +118,303 @@ func NewRequestHandler(broker Subscribable, cacheService Cacheable, logger logge
 		cacheService: cacheService,
 		key:          topic,
 		offset:       offset.NewOffset(),
+		heartbeat:    defaultHeartbeatInterval,
 	}
 }
 
+// Set the limits on the streams and replays of each consumer.
+func (h *RequestHandler) WithLimiter(limiter *limits.Limiter) *RequestHandler {
+	h.limiter = limiter
+	return h
+}
+
+// Set whether unauthenticated consumers are identified by the address in the X-Forwarded-For header
+// rather than that of the connection, which is the proxy's when the service is behind one.
+func (h *RequestHandler) WithTrustedForwardedFor(trust bool) *RequestHandler {
+	h.trustForwardedFor = trust
+	return h
+}
+
+// Set how long a stream may go without a delta before a heartbeat is written to the user.
+func (h *RequestHandler) WithHeartbeat(interval time.Duration) *RequestHandler {
+	if interval > 0 {
+		h.heartbeat = interval
+	}
+	return h
+}
+
 func (h *RequestHandler) HandleRequest(writer http.ResponseWriter, request *http.Request) {
+	if !h.begin() {
+		h.logger.InfoR(request, "Rejected connection as the service is shutting down")
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	defer h.streams.Done()
 
-	offset := request.URL.Query().Get("timepoint")
-	o, err := h.offset.Parse(offset)
+	if websocket.IsWebSocketUpgrade(request) {
+		h.processWebSocket(writer, request)
+		return
+	}
+
+	format, err := negotiateFormat(request)
 	if err != nil {
-		h.logger.Info("Invalid offset requested", log.Data{"error": err, "offset": offset})
+		h.logger.Info("Invalid format requested", log.Data{"error": err, "topic": h.key})
 		writer.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	h.logger.Info("Retrieved offset from the url", log.Data{"timepoint": o, "topic": h.key})
 
+	stream, o, ok := h.open(writer, request)
+	if !ok {
+		return
+	}
+	defer stream.release()
+	stream.writer = writer
+	stream.flush = writer.(http.Flusher).Flush
+	stream.format = format
+	stream.done = request.Context().Done()
+	writer.Header().Set("Content-Type", format.ContentType())
+	writer.Header().Set("Cache-Control", "no-cache")
+	writer.WriteHeader(http.StatusOK)
 	if o > 0 {
-		h.processOffset(writer, o)
+		stream.last = o - 1
+		h.processOffset(stream, o)
 	} else {
-		_, _ = writer.Write([]byte("\n"))
-		writer.(http.Flusher).Flush()
+		_ = format.Begin(writer)
 	}
-	h.processHttp(writer, request)
-	return
+	stream.flush()
+	h.processHttp(stream, request)
 }
 
-func (h *RequestHandler) processOffset(writer http.ResponseWriter, o int64) {
-	//TODO check offset is valid
-	h.logger.Info(" Retrieving cached deltas for the given offset", log.Data{"timepoint": o, "topic": h.key})
-	deltas, err := h.cacheService.Read(h.key, o)
+// Obtain and validate the requested offset and fields, check the consumer's limits, then subscribe
+// to the broker, returning the new stream and the offset to replay it from. If any of these fail an
+// error is written to the user and false returned.
+func (h *RequestHandler) open(writer http.ResponseWriter, request *http.Request) (*stream, int64, bool) {
+	var fields projection.Projection
+	if selectors := request.URL.Query().Get("fields"); selectors != "" {
+		var err error
+		if fields, err = projection.Parse(selectors); err != nil {
+			h.logger.Info("Invalid fields requested", log.Data{"error": err, "fields": selectors, "topic": h.key})
+			writer.WriteHeader(http.StatusBadRequest)
+			return nil, 0, false
+		}
+	}
+
+	o, err := h.requestedOffset(request)
+	if err != nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		return nil, 0, false
+	}
+
+	if o > 0 && !h.validateOffset(writer, o) {
+		return nil, 0, false
+	}
+
+	consumer := consumerOf(request, h.trustForwardedFor)
+	release, ok := h.acquireStream(writer, request, consumer)
+	if !ok {
+		return nil, 0, false
+	}
+	if o > 0 {
+		if problem := h.allowReplay(consumer); problem != nil {
+			h.logger.InfoR(request, "Rejected stream as the consumer has replayed too often", log.Data{"topic": h.key})
+			release()
+			h.writeTooManyRequests(writer, problem)
+			return nil, 0, false
+		}
+	}
+
+	// Subscribe before replaying the cache, so nothing published during the replay is missed.
+	subscriber := subscriberOf(request, o)
+	subscription, err := h.broker.Subscribe(subscriber)
+	if err != nil {
+		h.logger.Error(err, log.Data{"topic": h.key})
+		release()
+		writer.WriteHeader(http.StatusServiceUnavailable)
+		return nil, 0, false
+	}
+	h.logger.InfoR(request, "User connected", log.Data{"topic": h.key, "subscriber": subscriber.ID})
+	return &stream{
+		subscription: subscription,
+		subscriber:   subscriber,
+		filter:       newFilter(request.URL.Query()),
+		fields:       fields,
+		consumer:     consumer,
+		release:      release,
+		written:      time.Now(),
+		last:         -1,
+	}, o, true
+}
+
+// Describe the user making a request, as it will be listed among the subscribers of the topic.
+func subscriberOf(request *http.Request, o int64) *broker.Subscriber {
+	subscriber := &broker.Subscriber{
+		RemoteAddress: request.RemoteAddr,
+		RequestID:     request.Header.Get(requestIDHeader),
+		Timepoint:     o,
+	}
+	if key, ok := auth.FromContext(request.Context()); ok {
+		subscriber.Identity = key.Name
+	}
+	return subscriber
+}
+
+// Obtain the offset to stream from. A user reconnecting to a stream of server-sent events resumes
+// from the offset after the one in its Last-Event-ID header, in preference to the timepoint.
+func (h *RequestHandler) requestedOffset(request *http.Request) (int64, error) {
+	if lastEventID := request.Header.Get("Last-Event-ID"); lastEventID != "" {
+		o, err := h.offset.Parse(lastEventID)
+		if err != nil {
+			h.logger.Info("Invalid Last-Event-ID requested", log.Data{"error": err, "last_event_id": lastEventID})
+			return 0, err
+		}
+		h.logger.Info("Retrieved offset from the Last-Event-ID header", log.Data{"timepoint": o + 1, "topic": h.key})
+		return o + 1, nil
+	}
+	offset := request.URL.Query().Get("timepoint")
+	o, err := h.offset.Parse(offset)
+	if err != nil {
+		h.logger.Info("Invalid offset requested", log.Data{"error": err, "offset": offset})
+		return 0, err
+	}
+	h.logger.Info("Retrieved offset from the url", log.Data{"timepoint": o, "topic": h.key})
+	return o, nil
+}
+
+// The body of the response to a request for an offset that is not cached.
+type outOfRange struct {
+	Error     string `json:"error"`
+	Timepoint int64  `json:"timepoint"`
+	Oldest    int64  `json:"oldest"`
+	Newest    int64  `json:"newest"`
+}
+
+// Check the requested offset is within the range cached, responding with 416 if not.
+func (h *RequestHandler) validateOffset(writer http.ResponseWriter, o int64) bool {
+	problem := h.checkOffset(o)
+	if problem == nil {
+		return true
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+	_ = json.NewEncoder(writer).Encode(problem)
+	return false
+}
+
+// Check the requested offset is within the range cached, describing the range if not.
+func (h *RequestHandler) checkOffset(o int64) *outOfRange {
+	oldest, newest, err := h.cacheService.OffsetRange(h.key)
 	if err != nil {
 		h.logger.Error(err, log.Data{"timepoint": o, "topic": h.key})
-		return
+		return nil
+	}
+	if oldest == 0 && newest == 0 {
+		// Offsets may have been cached and since expired, in which case only the offset after the
+		// latest can be streamed.
+		latest, err := h.cacheService.LatestOffset(h.key)
+		if err != nil {
+			h.logger.Error(err, log.Data{"timepoint": o, "topic": h.key})
+			return nil
+		}
+		if latest > 0 {
+			oldest, newest = latest+1, latest
+		}
 	}
-	for _, delta := range deltas {
-		_, _ = writer.Write([]byte(delta + "\n"))
-		writer.(http.Flusher).Flush()
-		if h.wg != nil {
-			h.wg.Done()
+	if err := h.offset.Validate(o, oldest, newest); err != nil {
+		h.logger.Info("Requested offset out of range", log.Data{"timepoint": o, "oldest": oldest, "newest": newest, "topic": h.key})
+		return &outOfRange{
+			Error:     err.Error(),
+			Timepoint: o,
+			Oldest:    oldest,
+			Newest:    newest,
 		}
 	}
+	return nil
 }
 
-func (h *RequestHandler) processHttp(writer http.ResponseWriter, request *http.Request) bool {
-	h.logger.InfoR(request, "User connected")
-	subscription, _ := h.broker.Subscribe()
-	writer.WriteHeader(http.StatusOK)
+// Replay the cached deltas from the given offset. Live messages received in the meantime are
+// discarded, as every published message is cached first and so is either read by the replay or
+// caught up with once it has finished. The replay stops early if the stream ends.
+func (h *RequestHandler) processOffset(stream *stream, o int64) {
+	h.logger.Info(" Retrieving cached deltas for the given offset", log.Data{"timepoint": o, "topic": h.key})
+	entries := h.cacheService.Read(h.key, o)
+	for !stream.ended() && entries.Next() {
+		h.writeEntry(stream, entries.Entry())
+		stream.drain()
+	}
+	if err := entries.Err(); err != nil {
+		h.logger.Error(err, log.Data{"timepoint": o, "topic": h.key})
+	}
+	if stream.ended() {
+		return
+	}
+	stream.drain()
+	if stream.seen > stream.last {
+		h.catchUp(stream, stream.seen)
+	}
+}
+
+// Write the cached deltas following the last offset written, up to and including the given offset.
+func (h *RequestHandler) catchUp(stream *stream, to int64) {
+	entries := h.cacheService.Read(h.key, stream.last+1)
+	for !stream.ended() && entries.Next() && entries.Entry().Offset <= to {
+		h.writeEntry(stream, entries.Entry())
+	}
+	if err := entries.Err(); err != nil {
+		h.logger.Error(err, log.Data{"timepoint": stream.last + 1, "topic": h.key})
+	}
+}
+
+func (h *RequestHandler) processHttp(stream *stream, request *http.Request) bool {
+	writer := stream.writer
+	heartbeat := time.NewTimer(h.heartbeat)
+	defer heartbeat.Stop()
 	for {
 		select {
-		case msg := <-subscription:
-			_, _ = writer.Write([]byte(msg + "\n"))
-			writer.(http.Flusher).Flush()
-			if h.wg != nil {
-				h.wg.Done()
+		case msg, ok := <-stream.subscription:
+			if !ok {
+				_ = stream.format.WriteEndOfStream(writer)
+				stream.flush()
+				h.logger.InfoR(request, "Stream closed by the server")
+				if h.wg != nil {
+					h.wg.Done()
+				}
+				return true
+			}
+			if msg.Offset > 0 && stream.last >= 0 {
+				if msg.Offset <= stream.last {
+					// already written by the replay
+					continue
+				}
+				if msg.Offset > stream.last+1 {
+					h.catchUp(stream, msg.Offset-1)
+				}
+			}
+			h.write(stream, msg.Offset, msg.Data, msg.Attributes)
+		case o := <-stream.resume:
+			if problem := h.checkOffset(o); problem != nil {
+				_ = json.NewEncoder(writer).Encode(problem)
+				stream.flush()
+				continue
+			}
+			if problem := h.allowReplay(stream.consumer); problem != nil {
+				_ = json.NewEncoder(writer).Encode(problem)
+				stream.flush()
+				continue
 			}
-		case <-request.Context().Done():
-			_ = h.broker.Unsubscribe(subscription)
+			h.logger.InfoR(request, "User resumed from a new offset", log.Data{"timepoint": o})
+			stream.last = o - 1
+			h.processOffset(stream, o)
+		case <-heartbeat.C:
+			if idle := time.Since(stream.written); idle < h.heartbeat {
+				heartbeat.Reset(h.heartbeat - idle)
+				continue
+			}
+			_ = stream.format.WriteHeartbeat(writer)
+			stream.flush()
+			stream.written = time.Now()
+			heartbeat.Reset(h.heartbeat)
+		case <-stream.done:
+			_ = h.broker.Unsubscribe(stream.subscription)
 			h.logger.InfoR(request, "User disconnected")
 			if h.wg != nil {
 				h.wg.Done()
@@ -92,5 +422,68 @@ func (h *RequestHandler) processHttp(writer http.ResponseWriter, request *http.R
 			return true
 		}
 	}
-	return false
+}
+
+// Write a cached delta, parsing its attributes only if the user's stream is filtered.
+func (h *RequestHandler) writeEntry(stream *stream, entry Entry) {
+	var attributes delta.Attributes
+	if stream.filter != nil {
+		attributes = delta.Parse(entry.Delta)
+	}
+	h.write(stream, entry.Offset, entry.Delta, attributes)
+}
+
+// Write a delta to the user unless it is filtered out, recording its offset as the last streamed.
+func (h *RequestHandler) write(stream *stream, offset int64, data string, attributes delta.Attributes) {
+	if stream.filter.matches(attributes) {
+		if stream.fields != nil {
+			if projected, err := stream.fields.Apply(data); err == nil {
+				data = projected
+			}
+		}
+		if err := stream.format.WriteDelta(stream.writer, offset, data); err == nil {
+			stream.subscriber.Sent(len(data))
+		}
+		stream.flush()
+		stream.written = time.Now()
+	}
+	if offset > 0 && offset > stream.last {
+		stream.last = offset
+	}
+	if h.wg != nil {
+		h.wg.Done()
+	}
+}
+
+// Register a new stream, unless the handler has been closed.
+func (h *RequestHandler) begin() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.closed {
+		return false
+	}
+	h.streams.Add(1)
+	return true
+}
+
+// Close this handler so that new requests are rejected. Streams already in progress are unaffected.
+func (h *RequestHandler) Close() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.closed = true
+}
+
+// Wait for all streams in progress to end, or until the context is done.
+func (h *RequestHandler) Wait(ctx context.Context) error {
+	finished := make(chan struct{})
+	go func() {
+		h.streams.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
@@ -1,6 +1,12 @@
 package handlers
 
 import (
+	"context"
+	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/companieshouse/chs-streaming-api-cache/cache"
+	"github.com/companieshouse/chs-streaming-api-cache/delta"
 	"github.com/companieshouse/chs.go/log"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/mock"
@@ -46,9 +52,9 @@ func TestCreateNewRequestHandler(t *testing.T) {
 
 func TestWritePublishedMessageToResponseWriter(t *testing.T) {
 	Convey("Given a running request handler", t, func() {
-		subscription := make(chan string)
+		subscription := make(chan *broker.Message)
 		broker := &mockBroker{}
-		broker.On("Subscribe").Return(subscription, nil)
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
 		logger := &mockLogger{}
 		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
 		logger.On("Info", mock.Anything, mock.Anything).Return()
@@ -62,14 +68,14 @@ func TestWritePublishedMessageToResponseWriter(t *testing.T) {
 		go requestHandler.HandleRequest(response, request)
 		Convey("When a new message is published", func() {
 			waitGroup.Add(1)
-			subscription <- "Hello world"
+			subscription <- message(1, "Hello world")
 			waitGroup.Wait()
 			firstLine, _ := response.Body.ReadString('\n')
 			secondLine, _ := response.Body.ReadString('\n')
 			output := firstLine + secondLine
 			Convey("Then the message should be written to the output stream", func() {
 				So(logger.AssertCalled(t, "InfoR", request, "User connected", mock.Anything), ShouldBeTrue)
-				So(broker.AssertCalled(t, "Subscribe"), ShouldBeTrue)
+				So(broker.AssertCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
 				So(output, ShouldEqual, "\nHello world\n")
 			})
 		})
@@ -78,14 +84,15 @@ func TestWritePublishedMessageToResponseWriter(t *testing.T) {
 
 func TestWriteCachedMessageToResponseWriter(t *testing.T) {
 	Convey("Given a running request handler", t, func() {
-		subscription := make(chan string)
+		subscription := make(chan *broker.Message)
 		broker := &mockBroker{}
-		broker.On("Subscribe").Return(subscription, nil)
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
 		logger := &mockLogger{}
 		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
 		logger.On("Info", mock.Anything, mock.Anything).Return()
 		cacheService := &mockCacheService{}
-		cacheService.On("Read", mock.Anything, mock.Anything).Return([]string{"Hello from cache"}, nil)
+		cacheService.On("Read", mock.Anything, mock.Anything).Return(cache.NewEntryIterator(cache.Entry{Offset: 2, Delta: "Hello from cache"}))
+		cacheService.On("OffsetRange", "topic").Return(int64(1), int64(2), nil)
 		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
 		waitGroup := new(sync.WaitGroup)
 		requestHandler.wg = waitGroup
@@ -100,7 +107,7 @@ func TestWriteCachedMessageToResponseWriter(t *testing.T) {
 			Convey("Then the message should be written to the output stream", func() {
 				So(output, ShouldEqual, "Hello from cache\n")
 				So(logger.AssertCalled(t, "InfoR", request, "User connected", mock.Anything), ShouldBeTrue)
-				So(broker.AssertCalled(t, "Subscribe"), ShouldBeTrue)
+				So(broker.AssertCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
 			})
 		})
 	})
@@ -108,10 +115,10 @@ func TestWriteCachedMessageToResponseWriter(t *testing.T) {
 
 func TestHandlerUnsubscribesIfUserDisconnects(t *testing.T) {
 	Convey("Given a running request handler", t, func() {
-		subscription := make(chan string)
+		subscription := make(chan *broker.Message)
 		requestComplete := make(chan struct{})
 		broker := &mockBroker{}
-		broker.On("Subscribe").Return(subscription, nil)
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
 		broker.On("Unsubscribe", subscription).Return(nil)
 		cacheService := &mockCacheService{}
 		logger := &mockLogger{}
@@ -119,6 +126,7 @@ func TestHandlerUnsubscribesIfUserDisconnects(t *testing.T) {
 		logger.On("Info", mock.Anything, mock.Anything).Return()
 		context := &mockContext{}
 		context.On("Done").Return(requestComplete)
+		context.On("Value", mock.Anything).Return(nil)
 		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
 		waitGroup := new(sync.WaitGroup)
 		requestHandler.wg = waitGroup
@@ -132,7 +140,7 @@ func TestHandlerUnsubscribesIfUserDisconnects(t *testing.T) {
 			waitGroup.Wait()
 			Convey("Then the broker should be unsubscribed from the broker", func() {
 				So(logger.AssertCalled(t, "InfoR", request, "User connected", mock.Anything), ShouldBeTrue)
-				So(broker.AssertCalled(t, "Subscribe"), ShouldBeTrue)
+				So(broker.AssertCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
 				So(broker.AssertCalled(t, "Unsubscribe", subscription), ShouldBeTrue)
 				So(logger.AssertCalled(t, "InfoR", request, "User disconnected", mock.Anything), ShouldBeTrue)
 			})
@@ -140,12 +148,12 @@ func TestHandlerUnsubscribesIfUserDisconnects(t *testing.T) {
 	})
 }
 
-func (b *mockBroker) Subscribe() (chan string, error) {
-	args := b.Called()
-	return args.Get(0).(chan string), args.Error(1)
+func (b *mockBroker) Subscribe(subscriber *broker.Subscriber) (chan *broker.Message, error) {
+	args := b.Called(subscriber)
+	return args.Get(0).(chan *broker.Message), args.Error(1)
 }
 
-func (b *mockBroker) Unsubscribe(subscription chan string) error {
+func (b *mockBroker) Unsubscribe(subscription chan *broker.Message) error {
 	args := b.Called(subscription)
 	return args.Error(0)
 }
@@ -175,9 +183,19 @@ func (s *mockCacheService) Create(key string, delta string, offset int64) error
 	return args.Error(0)
 }
 
-func (s *mockCacheService) Read(key string, offset int64) ([]string, error) {
+func (s *mockCacheService) Read(key string, offset int64) cache.Iterator {
 	args := s.Called(key, offset)
-	return args.Get(0).([]string), args.Error(1)
+	return args.Get(0).(cache.Iterator)
+}
+
+func (s *mockCacheService) LatestOffset(key string) (int64, error) {
+	args := s.Called(key)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (s *mockCacheService) OffsetRange(key string) (int64, int64, error) {
+	args := s.Called(key)
+	return args.Get(0).(int64), args.Get(1).(int64), args.Error(2)
 }
 
 func (l *mockLogger) Info(msg string, data ...log.Data) {
@@ -191,3 +209,486 @@ func (l *mockLogger) InfoR(req *http.Request, msg string, data ...log.Data) {
 func (l *mockLogger) Error(err error, data ...log.Data) {
 	l.Called(err, data)
 }
+
+func TestHandlerWritesEndOfStreamWhenSubscriptionIsClosed(t *testing.T) {
+	Convey("Given a running request handler", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		request := httptest.NewRequest("GET", "/endpoint", nil)
+		response := httptest.NewRecorder()
+		go requestHandler.HandleRequest(response, request)
+		Convey("When the broker closes the subscription", func() {
+			waitGroup.Add(1)
+			close(subscription)
+			waitGroup.Wait()
+			So(requestHandler.Wait(context.Background()), ShouldBeNil)
+			Convey("Then an end of stream marker should be written to the output stream", func() {
+				So(response.Body.String(), ShouldEqual, "\n"+endOfStream+"\n")
+				So(logger.AssertCalled(t, "InfoR", request, "Stream closed by the server", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestHandlerRejectsRequestsOnceClosed(t *testing.T) {
+	Convey("Given a request handler that has been closed", t, func() {
+		broker := &mockBroker{}
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic")
+		requestHandler.Close()
+		Convey("When a user connects", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil)
+			response := httptest.NewRecorder()
+			requestHandler.HandleRequest(response, request)
+			Convey("Then the request should be rejected without subscribing", func() {
+				So(response.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(broker.AssertNotCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestRejectOffsetOutsideCachedRange(t *testing.T) {
+	Convey("Given a request handler for a topic with cached offsets from 10 to 20", t, func() {
+		broker := &mockBroker{}
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(10), int64(20), nil)
+		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
+		Convey("When an offset older than the oldest cached is requested", func() {
+			request := httptest.NewRequest("GET", "/endpoint?timepoint=5", nil)
+			response := httptest.NewRecorder()
+			requestHandler.HandleRequest(response, request)
+			Convey("Then the request should be rejected with the valid range", func() {
+				So(response.Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+				So(response.Header().Get("Content-Type"), ShouldEqual, "application/json")
+				So(response.Body.String(), ShouldEqual, "{\"error\":\"requested offset is out of range\",\"timepoint\":5,\"oldest\":10,\"newest\":20}\n")
+				So(broker.AssertNotCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
+				So(cacheService.AssertNotCalled(t, "Read", mock.Anything, mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestRejectOffsetOnceEveryCachedOffsetHasExpired(t *testing.T) {
+	Convey("Given a request handler for a topic whose cached offsets up to 20 have all expired", t, func() {
+		broker := &mockBroker{}
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(0), int64(0), nil)
+		cacheService.On("LatestOffset", "topic").Return(int64(20), nil)
+		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
+		Convey("When an expired offset is requested", func() {
+			request := httptest.NewRequest("GET", "/endpoint?timepoint=5", nil)
+			response := httptest.NewRecorder()
+			requestHandler.HandleRequest(response, request)
+			Convey("Then the request should be rejected with the offset after the latest as the only valid one", func() {
+				So(response.Code, ShouldEqual, http.StatusRequestedRangeNotSatisfiable)
+				So(response.Body.String(), ShouldEqual, "{\"error\":\"requested offset is out of range\",\"timepoint\":5,\"oldest\":21,\"newest\":20}\n")
+				So(broker.AssertNotCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
+			})
+		})
+		Convey("When the offset after the latest is checked", func() {
+			problem := requestHandler.checkOffset(21)
+			Convey("Then it should be accepted", func() {
+				So(problem, ShouldBeNil)
+			})
+		})
+	})
+}
+
+func TestDescribeSubscriberAndCountDeltasWritten(t *testing.T) {
+	Convey("Given a running request handler streaming to an authenticated user", t, func() {
+		subscription := make(chan *broker.Message)
+		subscribers := make(chan *broker.Subscriber, 1)
+		subscribable := &mockBroker{}
+		subscribable.On("Subscribe", mock.Anything).Run(func(args mock.Arguments) {
+			subscribers <- args.Get(0).(*broker.Subscriber)
+		}).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(subscribable, &mockCacheService{}, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		request := httptest.NewRequest("GET", "/endpoint", nil)
+		request.Header.Add("X-Request-Id", "123")
+		request = request.WithContext(auth.NewContext(request.Context(), &auth.Key{Key: "abc", Name: "filings consumer"}))
+		response := httptest.NewRecorder()
+		go requestHandler.HandleRequest(response, request)
+		subscriber := <-subscribers
+		Convey("When a message is published", func() {
+			waitGroup.Add(1)
+			subscription <- message(1, "Hello world")
+			waitGroup.Wait()
+			Convey("Then the user should be described to the broker, counting the delta written", func() {
+				So(subscriber.RemoteAddress, ShouldEqual, request.RemoteAddr)
+				So(subscriber.Identity, ShouldEqual, "filings consumer")
+				So(subscriber.RequestID, ShouldEqual, "123")
+				So(subscriber.Timepoint, ShouldEqual, 0)
+				So(subscriber.Messages(), ShouldEqual, 1)
+				So(subscriber.Bytes(), ShouldEqual, len("Hello world"))
+			})
+		})
+	})
+}
+
+func timepointDelta(timepoint int64) string {
+	return fmt.Sprintf("{\"event\":{\"timepoint\":%d}}", timepoint)
+}
+
+func message(offset int64, data string) *broker.Message {
+	return &broker.Message{Offset: offset, Topic: "topic", Data: data}
+}
+
+func TestSkipPublishedMessagesAlreadyReplayedFromCache(t *testing.T) {
+	Convey("Given a running request handler replaying offsets 1 and 2 from the cache", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(1), int64(2), nil)
+		cacheService.On("Read", "topic", int64(1)).Return(cache.NewEntryIterator(cache.Entry{Offset: 1, Delta: timepointDelta(1)}, cache.Entry{Offset: 2, Delta: timepointDelta(2)}))
+		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		request := httptest.NewRequest("GET", "/endpoint?timepoint=1", nil)
+		response := httptest.NewRecorder()
+		waitGroup.Add(2)
+		go requestHandler.HandleRequest(response, request)
+		waitGroup.Wait()
+		Convey("When offsets 2 and 3 are then published", func() {
+			waitGroup.Add(1)
+			subscription <- message(2, timepointDelta(2))
+			subscription <- message(3, timepointDelta(3))
+			waitGroup.Wait()
+			Convey("Then each offset should be written once and in order", func() {
+				So(response.Body.String(), ShouldEqual, timepointDelta(1)+"\n"+timepointDelta(2)+"\n"+timepointDelta(3)+"\n")
+				So(broker.AssertCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestCatchUpFromCacheWhenPublishedOffsetsAreMissed(t *testing.T) {
+	Convey("Given a running request handler that has written offset 4", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		cacheService := &mockCacheService{}
+		cacheService.On("Read", "topic", int64(5)).Return(cache.NewEntryIterator(cache.Entry{Offset: 5, Delta: timepointDelta(5)}, cache.Entry{Offset: 6, Delta: timepointDelta(6)}, cache.Entry{Offset: 7, Delta: timepointDelta(7)}))
+		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		request := httptest.NewRequest("GET", "/endpoint", nil)
+		response := httptest.NewRecorder()
+		go requestHandler.HandleRequest(response, request)
+		waitGroup.Add(1)
+		subscription <- message(4, timepointDelta(4))
+		waitGroup.Wait()
+		Convey("When offset 7 is published next", func() {
+			waitGroup.Add(3)
+			subscription <- message(7, timepointDelta(7))
+			waitGroup.Wait()
+			Convey("Then the missing offsets should be written from the cache before it", func() {
+				So(response.Body.String(), ShouldEqual, "\n"+timepointDelta(4)+"\n"+timepointDelta(5)+"\n"+timepointDelta(6)+"\n"+timepointDelta(7)+"\n")
+				So(cacheService.AssertCalled(t, "Read", "topic", int64(5)), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+// An Iterator over an endless history of offsets, calling reached once the given offset is read.
+type endlessIterator struct {
+	offset  int64
+	at      int64
+	reached func()
+}
+
+func (i *endlessIterator) Next() bool {
+	i.offset++
+	if i.offset == i.at {
+		i.reached()
+	}
+	return true
+}
+
+func (i *endlessIterator) Entry() cache.Entry {
+	return cache.Entry{Offset: i.offset, Delta: timepointDelta(i.offset)}
+}
+
+func (i *endlessIterator) Err() error {
+	return nil
+}
+
+func TestStopReplayingCacheOnceStreamEnds(t *testing.T) {
+	Convey("Given a user replaying an endless history from the cache", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		broker.On("Unsubscribe", subscription).Return(nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		request := httptest.NewRequest("GET", "/endpoint?timepoint=1", nil).WithContext(ctx)
+		finished := make(chan struct{})
+		replay := func(reached func()) {
+			cacheService := &mockCacheService{}
+			cacheService.On("OffsetRange", "topic").Return(int64(1), int64(1000), nil)
+			cacheService.On("Read", "topic", int64(1)).Return(&endlessIterator{at: 10, reached: reached})
+			requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
+			go func() {
+				requestHandler.HandleRequest(httptest.NewRecorder(), request)
+				close(finished)
+			}()
+		}
+		Convey("When the user disconnects during the replay", func() {
+			replay(cancel)
+			Convey("Then the replay should stop and the user be unsubscribed", func() {
+				select {
+				case <-finished:
+				case <-time.After(time.Second):
+					t.Fatal("replay did not stop")
+				}
+				So(broker.AssertCalled(t, "Unsubscribe", subscription), ShouldBeTrue)
+			})
+		})
+		Convey("When the subscription is closed during the replay", func() {
+			replay(func() { close(subscription) })
+			Convey("Then the replay should stop", func() {
+				select {
+				case <-finished:
+				case <-time.After(time.Second):
+					t.Fatal("replay did not stop")
+				}
+				So(logger.AssertCalled(t, "InfoR", request, "Stream closed by the server", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestWriteServerSentEventsWhenRequested(t *testing.T) {
+	Convey("Given a running request handler with a user accepting server-sent events", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		request := httptest.NewRequest("GET", "/endpoint", nil)
+		request.Header.Set("Accept", "text/event-stream")
+		response := httptest.NewRecorder()
+		go requestHandler.HandleRequest(response, request)
+		Convey("When a message is published and the subscription is then closed", func() {
+			waitGroup.Add(2)
+			subscription <- message(3, timepointDelta(3))
+			close(subscription)
+			waitGroup.Wait()
+			Convey("Then the message should be written as an event identified by its offset", func() {
+				So(response.Header().Get("Content-Type"), ShouldEqual, "text/event-stream")
+				So(response.Body.String(), ShouldEqual, "id: 3\ndata: "+timepointDelta(3)+"\n\nevent: end_of_stream\ndata: "+endOfStream+"\n\n")
+			})
+		})
+	})
+}
+
+func TestResumeServerSentEventsFromLastEventID(t *testing.T) {
+	Convey("Given a request handler for a topic with cached offsets from 1 to 10", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(1), int64(10), nil)
+		cacheService.On("Read", "topic", int64(5)).Return(cache.NewEntryIterator(cache.Entry{Offset: 5, Delta: timepointDelta(5)}))
+		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		Convey("When a user reconnects with the Last-Event-ID of offset 4", func() {
+			request := httptest.NewRequest("GET", "/endpoint?timepoint=1", nil)
+			request.Header.Set("Accept", "text/event-stream")
+			request.Header.Set("Last-Event-ID", "4")
+			response := httptest.NewRecorder()
+			waitGroup.Add(1)
+			go requestHandler.HandleRequest(response, request)
+			waitGroup.Wait()
+			Convey("Then the stream should resume from offset 5 rather than the timepoint", func() {
+				So(cacheService.AssertCalled(t, "Read", "topic", int64(5)), ShouldBeTrue)
+				So(response.Body.String(), ShouldEqual, "id: 5\ndata: "+timepointDelta(5)+"\n\n")
+			})
+		})
+	})
+}
+
+func TestRejectUnknownFormat(t *testing.T) {
+	Convey("Given a running request handler", t, func() {
+		broker := &mockBroker{}
+		logger := &mockLogger{}
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic")
+		Convey("When a user requests an unknown format", func() {
+			request := httptest.NewRequest("GET", "/endpoint?format=xml", nil)
+			response := httptest.NewRecorder()
+			requestHandler.HandleRequest(response, request)
+			Convey("Then the request should be rejected without subscribing", func() {
+				So(response.Code, ShouldEqual, http.StatusBadRequest)
+				So(broker.AssertNotCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+// A response recorder passing each write to the test.
+type writeRecorder struct {
+	*httptest.ResponseRecorder
+	writes chan string
+}
+
+func (r *writeRecorder) Write(p []byte) (int, error) {
+	r.writes <- string(p)
+	return len(p), nil
+}
+
+func TestWriteHeartbeatWhenStreamIsIdle(t *testing.T) {
+	Convey("Given a running request handler with a short heartbeat interval", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		broker.On("Unsubscribe", subscription).Return(nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic").WithHeartbeat(10 * time.Millisecond)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		response := &writeRecorder{ResponseRecorder: httptest.NewRecorder(), writes: make(chan string, 10)}
+		Convey("When no messages are published to a user streaming newline delimited JSON", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil).WithContext(ctx)
+			go requestHandler.HandleRequest(response, request)
+			first, second := <-response.writes, <-response.writes
+			Convey("Then a blank line should be written as a heartbeat", func() {
+				So(first, ShouldEqual, "\n")
+				So(second, ShouldEqual, "\n")
+			})
+		})
+		Convey("When no messages are published to a user streaming server-sent events", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil).WithContext(ctx)
+			request.Header.Set("Accept", "text/event-stream")
+			go requestHandler.HandleRequest(response, request)
+			first := <-response.writes
+			Convey("Then a comment should be written as a heartbeat", func() {
+				So(first, ShouldEqual, ": heartbeat\n\n")
+			})
+		})
+	})
+}
+
+func companyDelta(timepoint int64, companyNumber string) string {
+	return fmt.Sprintf("{\"resource_kind\":\"company-profile\",\"resource_uri\":\"/company/%s\",\"event\":{\"timepoint\":%d}}", companyNumber, timepoint)
+}
+
+func TestFilterCachedAndPublishedMessagesByCompanyNumber(t *testing.T) {
+	Convey("Given a running request handler with offsets 1 and 2 cached for different companies", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(1), int64(2), nil)
+		cacheService.On("Read", "topic", int64(1)).Return(cache.NewEntryIterator(
+			cache.Entry{Offset: 1, Delta: companyDelta(1, "00000001")},
+			cache.Entry{Offset: 2, Delta: companyDelta(2, "00000002")},
+		))
+		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		request := httptest.NewRequest("GET", "/endpoint?timepoint=1&company_number=00000002", nil)
+		response := httptest.NewRecorder()
+		waitGroup.Add(2)
+		go requestHandler.HandleRequest(response, request)
+		waitGroup.Wait()
+		Convey("When offsets for each company are then published", func() {
+			waitGroup.Add(2)
+			published := message(3, companyDelta(3, "00000001"))
+			published.Attributes = delta.Attributes{ResourceKind: "company-profile", CompanyNumber: "00000001"}
+			subscription <- published
+			published = message(4, companyDelta(4, "00000002"))
+			published.Attributes = delta.Attributes{ResourceKind: "company-profile", CompanyNumber: "00000002"}
+			subscription <- published
+			waitGroup.Wait()
+			Convey("Then only the offsets for the requested company should be written", func() {
+				So(response.Body.String(), ShouldEqual, companyDelta(2, "00000002")+"\n"+companyDelta(4, "00000002")+"\n")
+			})
+		})
+	})
+}
+
+func TestWriteRequestedFieldsOfPublishedMessages(t *testing.T) {
+	Convey("Given a running request handler with a user requesting some fields", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic")
+		waitGroup := new(sync.WaitGroup)
+		requestHandler.wg = waitGroup
+		request := httptest.NewRequest("GET", "/endpoint?fields=resource_uri,event.timepoint", nil)
+		response := httptest.NewRecorder()
+		go requestHandler.HandleRequest(response, request)
+		Convey("When a message is published", func() {
+			waitGroup.Add(1)
+			subscription <- message(3, companyDelta(3, "00000001"))
+			waitGroup.Wait()
+			Convey("Then only the requested fields should be written", func() {
+				So(response.Body.String(), ShouldEqual, "\n{\"event\":{\"timepoint\":3},\"resource_uri\":\"/company/00000001\"}\n")
+			})
+		})
+	})
+}
+
+func TestRejectInvalidFields(t *testing.T) {
+	Convey("Given a running request handler", t, func() {
+		broker := &mockBroker{}
+		logger := &mockLogger{}
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic")
+		Convey("When a user requests an invalid field selector", func() {
+			request := httptest.NewRequest("GET", "/endpoint?fields=event..timepoint", nil)
+			response := httptest.NewRecorder()
+			requestHandler.HandleRequest(response, request)
+			Convey("Then the request should be rejected without subscribing", func() {
+				So(response.Code, ShouldEqual, http.StatusBadRequest)
+				So(broker.AssertNotCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
+	"github.com/companieshouse/chs.go/log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a consumer with too many streams open is asked to wait before trying again.
+const streamRetryAfter = 10 * time.Second
+
+// The body of the response to a consumer that has exceeded a limit.
+type tooManyRequests struct {
+	Error      string `json:"error"`
+	RetryAfter int64  `json:"retry_after"`
+}
+
+// Identify the consumer making a request by its API key, or by its address if it is not
+// authenticated. Behind a proxy, the address is the proxy's unless the X-Forwarded-For header is
+// trusted, in which case it is the last address added to the header, by the proxy in front of the
+// service.
+func consumerOf(request *http.Request, trustForwardedFor bool) string {
+	if key, ok := auth.FromContext(request.Context()); ok {
+		return "key:" + key.Key
+	}
+	if trustForwardedFor {
+		if forwarded := request.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			addresses := strings.Split(forwarded[len(forwarded)-1], ",")
+			if address := strings.TrimSpace(addresses[len(addresses)-1]); address != "" {
+				return "address:" + address
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		host = request.RemoteAddr
+	}
+	return "address:" + host
+}
+
+// Acquire a stream for a consumer, responding with 429 if it has too many open. The returned
+// function releases the stream once it has ended.
+func (h *RequestHandler) acquireStream(writer http.ResponseWriter, request *http.Request, consumer string) (func(), bool) {
+	if h.limiter == nil {
+		return func() {}, true
+	}
+	release, ok := h.limiter.Acquire(consumer)
+	if !ok {
+		h.logger.InfoR(request, "Rejected stream as the consumer has too many open", log.Data{"topic": h.key})
+		h.rejectTooManyRequests(writer, "streams", streamRetryAfter)
+		return nil, false
+	}
+	return release, true
+}
+
+// Allow a consumer to replay the cache, returning a description of the limit exceeded if not.
+func (h *RequestHandler) allowReplay(consumer string) *tooManyRequests {
+	if h.limiter == nil {
+		return nil
+	}
+	retryAfter, ok := h.limiter.AllowReplay(consumer)
+	if ok {
+		return nil
+	}
+	metrics.RequestsLimited.WithLabelValues(h.key, "replays").Inc()
+	return &tooManyRequests{Error: "too many replays", RetryAfter: seconds(retryAfter)}
+}
+
+// Respond with 429, telling the consumer how long to wait before trying again.
+func (h *RequestHandler) rejectTooManyRequests(writer http.ResponseWriter, limit string, retryAfter time.Duration) {
+	metrics.RequestsLimited.WithLabelValues(h.key, limit).Inc()
+	h.writeTooManyRequests(writer, &tooManyRequests{Error: "too many " + limit, RetryAfter: seconds(retryAfter)})
+}
+
+func (h *RequestHandler) writeTooManyRequests(writer http.ResponseWriter, problem *tooManyRequests) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Retry-After", strconv.FormatInt(problem.RetryAfter, 10))
+	writer.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(writer).Encode(problem)
+}
+
+// The whole number of seconds to wait, rounded up.
+func seconds(d time.Duration) int64 {
+	return int64(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/companieshouse/chs-streaming-api-cache/cache"
+	"github.com/companieshouse/chs-streaming-api-cache/limits"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIdentifyConsumer(t *testing.T) {
+	Convey("Given requests with and without an API key", t, func() {
+		anonymous := httptest.NewRequest("GET", "/endpoint", nil)
+		anonymous.RemoteAddr = "10.0.0.1:1234"
+		authenticated := anonymous.WithContext(auth.NewContext(anonymous.Context(), &auth.Key{Key: "abc"}))
+		Convey("Then consumers should be identified by their key, or by their address", func() {
+			So(consumerOf(authenticated, false), ShouldEqual, "key:abc")
+			So(consumerOf(anonymous, false), ShouldEqual, "address:10.0.0.1")
+		})
+	})
+	Convey("Given a request forwarded by a proxy", t, func() {
+		forwarded := httptest.NewRequest("GET", "/endpoint", nil)
+		forwarded.RemoteAddr = "10.0.0.1:1234"
+		forwarded.Header.Add("X-Forwarded-For", "192.0.2.7, 198.51.100.2")
+		forwarded.Header.Add("X-Forwarded-For", "203.0.113.9")
+		Convey("Then the consumer should be identified by the address added by the proxy only if the header is trusted", func() {
+			So(consumerOf(forwarded, true), ShouldEqual, "address:203.0.113.9")
+			So(consumerOf(forwarded, false), ShouldEqual, "address:10.0.0.1")
+		})
+	})
+}
+
+func TestRejectConsumerWithTooManyStreams(t *testing.T) {
+	Convey("Given a request handler allowing one stream per consumer, which is in use", t, func() {
+		broker := &mockBroker{}
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		limiter := limits.NewLimiter(1, 0, 0)
+		_, _ = limiter.Acquire("address:192.0.2.1")
+		requestHandler := NewRequestHandler(broker, &mockCacheService{}, logger, "topic").WithLimiter(limiter)
+		Convey("When the consumer opens another stream", func() {
+			request := httptest.NewRequest("GET", "/endpoint", nil)
+			response := httptest.NewRecorder()
+			requestHandler.HandleRequest(response, request)
+			Convey("Then it should be rejected with 429 without subscribing", func() {
+				So(response.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(response.Header().Get("Retry-After"), ShouldEqual, "10")
+				So(response.Body.String(), ShouldEqual, "{\"error\":\"too many streams\",\"retry_after\":10}\n")
+				So(broker.AssertNotCalled(t, "Subscribe", mock.Anything), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestRejectConsumerReplayingTooOften(t *testing.T) {
+	Convey("Given a request handler allowing one replay per minute per consumer", t, func() {
+		subscription := make(chan *broker.Message)
+		close(subscription)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		logger := &mockLogger{}
+		logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+		logger.On("Info", mock.Anything, mock.Anything).Return()
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(0), int64(0), nil)
+		cacheService.On("LatestOffset", "topic").Return(int64(0), nil)
+		cacheService.On("Read", "topic", int64(1)).Return(cache.NewEntryIterator())
+		requestHandler := NewRequestHandler(broker, cacheService, logger, "topic").WithLimiter(limits.NewLimiter(0, 1, 1))
+		requestHandler.HandleRequest(httptest.NewRecorder(), httptest.NewRequest("GET", "/endpoint?timepoint=1", nil))
+		Convey("When the consumer replays the cache again", func() {
+			response := httptest.NewRecorder()
+			requestHandler.HandleRequest(response, httptest.NewRequest("GET", "/endpoint?timepoint=1", nil))
+			Convey("Then it should be rejected with 429 until a replay is allowed", func() {
+				So(response.Code, ShouldEqual, http.StatusTooManyRequests)
+				So(response.Header().Get("Retry-After"), ShouldEqual, "60")
+				So(broker.AssertNumberOfCalls(t, "Subscribe", 1), ShouldBeTrue)
+			})
+		})
+	})
+}
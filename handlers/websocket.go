@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/companieshouse/chs.go/log"
+	"github.com/gorilla/websocket"
+	"io"
+	"net/http"
+	"time"
+)
+
+// The time allowed to send a close message or ping to a WebSocket user.
+const closeTimeout = time.Second
+
+// Upgrades are refused for browsers on another origin, as unlike the chunked HTTP streams, a page on
+// any origin could otherwise read the stream using credentials the browser holds for this one.
+// Users outside a browser send no Origin header and are not affected.
+var upgrader = websocket.Upgrader{}
+
+// A control message sent by a WebSocket user to resume the stream from another timepoint.
+type controlMessage struct {
+	Timepoint *int64 `json:"timepoint"`
+}
+
+// Stream deltas over a WebSocket connection, in the same sequence as the chunked HTTP streams with
+// each delta sent as a text message. Pings sent by the user are answered with pongs.
+func (h *RequestHandler) processWebSocket(writer http.ResponseWriter, request *http.Request) {
+	stream, o, ok := h.open(writer, request)
+	if !ok {
+		return
+	}
+	defer stream.release()
+	conn, err := upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		h.logger.Error(err, log.Data{"topic": h.key})
+		_ = h.broker.Unsubscribe(stream.subscription)
+		return
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	resume := make(chan int64)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go h.readControlMessages(conn, resume, done, stopped)
+
+	stream.writer = &webSocketWriter{conn: conn}
+	stream.flush = func() {}
+	stream.format = webSocketFormat{conn: conn}
+	stream.done = done
+	stream.resume = resume
+	if o > 0 {
+		stream.last = o - 1
+		h.processOffset(stream, o)
+	}
+	h.processHttp(stream, request)
+	_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(closeTimeout))
+}
+
+// Read the control messages sent by a WebSocket user until it disconnects, at which point done is
+// closed. Reading also answers the pings the user sends.
+func (h *RequestHandler) readControlMessages(conn *websocket.Conn, resume chan<- int64, done chan<- struct{}, stopped <-chan struct{}) {
+	defer close(done)
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		msg := &controlMessage{}
+		if err := json.Unmarshal(data, msg); err != nil || msg.Timepoint == nil || *msg.Timepoint < 0 {
+			h.logger.Info("Invalid control message received", log.Data{"message": string(data), "topic": h.key})
+			continue
+		}
+		select {
+		case resume <- *msg.Timepoint:
+		case <-stopped:
+			return
+		}
+	}
+}
+
+// A writer sending each write as a text message over a WebSocket connection.
+type webSocketWriter struct {
+	conn *websocket.Conn
+}
+
+func (w *webSocketWriter) Write(p []byte) (int, error) {
+	if err := w.conn.WriteMessage(websocket.TextMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// The format of deltas sent over a WebSocket connection, where each message holds a single delta
+// and heartbeats are sent as pings.
+type webSocketFormat struct {
+	conn *websocket.Conn
+}
+
+func (f webSocketFormat) Name() string {
+	return "websocket"
+}
+
+func (f webSocketFormat) ContentType() string {
+	return ""
+}
+
+func (f webSocketFormat) Begin(w io.Writer) error {
+	return nil
+}
+
+func (f webSocketFormat) WriteDelta(w io.Writer, offset int64, data string) error {
+	_, err := w.Write([]byte(data))
+	return err
+}
+
+func (f webSocketFormat) WriteEndOfStream(w io.Writer) error {
+	_, err := w.Write([]byte(endOfStream))
+	return err
+}
+
+func (f webSocketFormat) WriteHeartbeat(w io.Writer) error {
+	return f.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(closeTimeout))
+}
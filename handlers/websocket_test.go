@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/companieshouse/chs-streaming-api-cache/cache"
+	"github.com/gorilla/websocket"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Start a server for the request handler, returning the WebSocket url of the given path.
+func newWebSocketServer(requestHandler *RequestHandler, path string) (*httptest.Server, string) {
+	server := httptest.NewServer(http.HandlerFunc(requestHandler.HandleRequest))
+	return server, "ws" + strings.TrimPrefix(server.URL, "http") + path
+}
+
+func newWebSocketLogger() *mockLogger {
+	logger := &mockLogger{}
+	logger.On("InfoR", mock.Anything, mock.Anything, mock.Anything).Return()
+	logger.On("Info", mock.Anything, mock.Anything).Return()
+	logger.On("Error", mock.Anything, mock.Anything).Return()
+	return logger
+}
+
+func TestStreamCachedThenPublishedMessagesOverWebSocket(t *testing.T) {
+	Convey("Given a request handler for a topic with offset 1 cached", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		broker.On("Unsubscribe", subscription).Return(nil)
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(1), int64(1), nil)
+		cacheService.On("Read", "topic", int64(1)).Return(cache.NewEntryIterator(cache.Entry{Offset: 1, Delta: timepointDelta(1)}))
+		server, url := newWebSocketServer(NewRequestHandler(broker, cacheService, newWebSocketLogger(), "topic"), "/endpoint?timepoint=1")
+		defer server.Close()
+		Convey("When a user connects over a WebSocket and offset 2 is published", func() {
+			conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+			So(err, ShouldBeNil)
+			defer conn.Close()
+			_, first, _ := conn.ReadMessage()
+			subscription <- message(2, timepointDelta(2))
+			_, second, _ := conn.ReadMessage()
+			Convey("Then the cached offset should be sent followed by the published one", func() {
+				So(string(first), ShouldEqual, timepointDelta(1))
+				So(string(second), ShouldEqual, timepointDelta(2))
+			})
+		})
+	})
+}
+
+func TestResumeFromTimepointSentOverWebSocket(t *testing.T) {
+	Convey("Given a user connected over a WebSocket to a topic with offsets 1 to 10 cached", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		broker.On("Unsubscribe", subscription).Return(nil)
+		cacheService := &mockCacheService{}
+		cacheService.On("OffsetRange", "topic").Return(int64(1), int64(10), nil)
+		cacheService.On("Read", "topic", int64(3)).Return(cache.NewEntryIterator(cache.Entry{Offset: 3, Delta: timepointDelta(3)}))
+		server, url := newWebSocketServer(NewRequestHandler(broker, cacheService, newWebSocketLogger(), "topic"), "/endpoint")
+		defer server.Close()
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		Convey("When the user asks to resume from a timepoint", func() {
+			So(conn.WriteMessage(websocket.TextMessage, []byte("{\"timepoint\":3}")), ShouldBeNil)
+			_, resumed, _ := conn.ReadMessage()
+			So(conn.WriteMessage(websocket.TextMessage, []byte("{\"timepoint\":50}")), ShouldBeNil)
+			_, rejected, _ := conn.ReadMessage()
+			Convey("Then the cached offsets from it should be sent, and timepoints out of range rejected", func() {
+				So(string(resumed), ShouldEqual, timepointDelta(3))
+				So(string(rejected), ShouldEqual, "{\"error\":\"requested offset is out of range\",\"timepoint\":50,\"oldest\":1,\"newest\":10}\n")
+			})
+		})
+	})
+}
+
+func TestAnswerPingsOverWebSocket(t *testing.T) {
+	Convey("Given a user connected over a WebSocket", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		broker.On("Unsubscribe", subscription).Return(nil)
+		server, url := newWebSocketServer(NewRequestHandler(broker, &mockCacheService{}, newWebSocketLogger(), "topic"), "/endpoint")
+		defer server.Close()
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		pong := make(chan string, 1)
+		conn.SetPongHandler(func(data string) error {
+			pong <- data
+			return nil
+		})
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		Convey("When the user sends a ping", func() {
+			So(conn.WriteControl(websocket.PingMessage, []byte("hello"), time.Now().Add(time.Second)), ShouldBeNil)
+			Convey("Then a pong should be received", func() {
+				select {
+				case data := <-pong:
+					So(data, ShouldEqual, "hello")
+				case <-time.After(time.Second):
+					t.Fatal("no pong received")
+				}
+			})
+		})
+	})
+}
+
+func TestRejectWebSocketUpgradesFromAnotherOrigin(t *testing.T) {
+	Convey("Given a request handler accepting WebSocket upgrades", t, func() {
+		subscription := make(chan *broker.Message)
+		broker := &mockBroker{}
+		broker.On("Subscribe", mock.Anything).Return(subscription, nil)
+		broker.On("Unsubscribe", subscription).Return(nil)
+		server, url := newWebSocketServer(NewRequestHandler(broker, &mockCacheService{}, newWebSocketLogger(), "topic"), "/endpoint")
+		defer server.Close()
+		Convey("When a browser on another origin connects", func() {
+			conn, response, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"https://attacker.example"}})
+			Convey("Then the upgrade should be forbidden", func() {
+				So(conn, ShouldBeNil)
+				So(err, ShouldNotBeNil)
+				So(response.StatusCode, ShouldEqual, http.StatusForbidden)
+			})
+		})
+		Convey("When a browser on the same origin connects", func() {
+			conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {server.URL}})
+			Convey("Then the upgrade should succeed", func() {
+				So(err, ShouldBeNil)
+				conn.Close()
+			})
+		})
+	})
+}
@@ -0,0 +1,119 @@
+// Package health provides liveness and readiness checks for the cache service.
+package health
+
+import (
+	"encoding/json"
+	"github.com/companieshouse/chs-streaming-api-cache/client"
+	"net/http"
+	"time"
+)
+
+// A cache that can check whether it is reachable.
+type Pinger interface {
+	Ping() error
+}
+
+// A backend client that reports the state of its stream.
+type StatusReporter interface {
+	Status() client.Status
+}
+
+// The components serving a single topic.
+type Topic struct {
+	Name   string
+	Cache  Pinger
+	Client StatusReporter
+}
+
+// The readiness of a single topic.
+type TopicStatus struct {
+	Topic        string     `json:"topic"`
+	Ready        bool       `json:"ready"`
+	Cache        string     `json:"cache"`
+	Connected    bool       `json:"connected"`
+	Paused       bool       `json:"paused,omitempty"`
+	LastReceived *time.Time `json:"last_received,omitempty"`
+	Offset       int64      `json:"offset"`
+}
+
+// The readiness of the service.
+type Status struct {
+	Ready  bool          `json:"ready"`
+	Topics []TopicStatus `json:"topics"`
+}
+
+// Checks the readiness of each topic served.
+type Checker struct {
+	topics    func() []Topic
+	staleness time.Duration
+}
+
+// Create a new Checker for the topics returned by the given function. A topic whose backend stream
+// has not received data within the staleness window is not ready; a zero window disables this check.
+func NewChecker(topics func() []Topic, staleness time.Duration) *Checker {
+	return &Checker{
+		topics:    topics,
+		staleness: staleness,
+	}
+}
+
+// Check the readiness of every topic.
+func (c *Checker) Check() Status {
+	status := Status{Ready: true, Topics: []TopicStatus{}}
+	for _, topic := range c.topics() {
+		topicStatus := c.checkTopic(topic)
+		status.Ready = status.Ready && topicStatus.Ready
+		status.Topics = append(status.Topics, topicStatus)
+	}
+	return status
+}
+
+func (c *Checker) checkTopic(topic Topic) TopicStatus {
+	status := TopicStatus{Topic: topic.Name, Ready: true, Cache: "ok"}
+	if topic.Cache != nil {
+		if err := topic.Cache.Ping(); err != nil {
+			status.Ready = false
+			status.Cache = err.Error()
+		}
+	}
+	if topic.Client != nil {
+		clientStatus := topic.Client.Status()
+		status.Connected = clientStatus.Connected
+		status.Paused = clientStatus.Paused
+		status.Offset = clientStatus.Offset
+		if !clientStatus.LastReceived.IsZero() {
+			status.LastReceived = &clientStatus.LastReceived
+		}
+		// A paused topic has been disconnected from the backend on purpose, so remains ready.
+		if clientStatus.Paused {
+			return status
+		}
+		if !clientStatus.Connected {
+			status.Ready = false
+		} else if c.staleness > 0 && time.Since(clientStatus.LastReceived) > c.staleness {
+			status.Ready = false
+		}
+	}
+	return status
+}
+
+// HandleLiveness responds with 200 for as long as the service is able to handle requests.
+func (c *Checker) HandleLiveness(writer http.ResponseWriter, request *http.Request) {
+	writeJSON(writer, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// HandleReadiness responds with the status of each topic, and 200 if all are ready or 503 otherwise.
+func (c *Checker) HandleReadiness(writer http.ResponseWriter, request *http.Request) {
+	status := c.Check()
+	code := http.StatusOK
+	if !status.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(writer, code, status)
+}
+
+func writeJSON(writer http.ResponseWriter, code int, body interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	_ = json.NewEncoder(writer).Encode(body)
+}
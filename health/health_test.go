@@ -0,0 +1,123 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/companieshouse/chs-streaming-api-cache/client"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type stubPinger struct {
+	err error
+}
+
+func (p *stubPinger) Ping() error {
+	return p.err
+}
+
+type stubStatusReporter struct {
+	status client.Status
+}
+
+func (r *stubStatusReporter) Status() client.Status {
+	return r.status
+}
+
+func topics(topics ...Topic) func() []Topic {
+	return func() []Topic {
+		return topics
+	}
+}
+
+func TestReadinessWhenAllTopicsAreHealthy(t *testing.T) {
+	Convey("Given a topic whose cache is reachable and backend stream has recently received data", t, func() {
+		checker := NewChecker(topics(Topic{
+			Name:   "topic",
+			Cache:  &stubPinger{},
+			Client: &stubStatusReporter{client.Status{Connected: true, LastReceived: time.Now(), Offset: 12}},
+		}), time.Minute)
+		Convey("When readiness is requested", func() {
+			response := httptest.NewRecorder()
+			checker.HandleReadiness(response, httptest.NewRequest("GET", "/healthcheck/ready", nil))
+			status := &Status{}
+			_ = json.Unmarshal(response.Body.Bytes(), status)
+			Convey("Then the service should be ready", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(status.Ready, ShouldBeTrue)
+				So(status.Topics, ShouldHaveLength, 1)
+				So(status.Topics[0].Topic, ShouldEqual, "topic")
+				So(status.Topics[0].Cache, ShouldEqual, "ok")
+				So(status.Topics[0].Offset, ShouldEqual, 12)
+			})
+		})
+	})
+}
+
+func TestReadinessWhenCacheIsUnreachable(t *testing.T) {
+	Convey("Given a topic whose cache cannot be reached", t, func() {
+		checker := NewChecker(topics(Topic{
+			Name:   "topic",
+			Cache:  &stubPinger{errors.New("connection refused")},
+			Client: &stubStatusReporter{client.Status{Connected: true, LastReceived: time.Now()}},
+		}), time.Minute)
+		Convey("When readiness is checked", func() {
+			status := checker.Check()
+			Convey("Then the topic should not be ready", func() {
+				So(status.Ready, ShouldBeFalse)
+				So(status.Topics[0].Cache, ShouldEqual, "connection refused")
+			})
+		})
+	})
+}
+
+func TestReadinessWhenBackendStreamIsDisconnectedOrStale(t *testing.T) {
+	Convey("Given one topic disconnected from the backend and another that has not received data recently", t, func() {
+		checker := NewChecker(topics(
+			Topic{Name: "disconnected", Client: &stubStatusReporter{client.Status{}}},
+			Topic{Name: "stale", Client: &stubStatusReporter{client.Status{Connected: true, LastReceived: time.Now().Add(-time.Hour)}}},
+		), time.Minute)
+		Convey("When readiness is requested", func() {
+			response := httptest.NewRecorder()
+			checker.HandleReadiness(response, httptest.NewRequest("GET", "/healthcheck/ready", nil))
+			status := checker.Check()
+			Convey("Then neither topic should be ready", func() {
+				So(response.Code, ShouldEqual, http.StatusServiceUnavailable)
+				So(status.Topics[0].Ready, ShouldBeFalse)
+				So(status.Topics[1].Ready, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestReadinessWhenBackendStreamIsPaused(t *testing.T) {
+	Convey("Given a topic whose backend stream has been paused, and has not received data since", t, func() {
+		checker := NewChecker(topics(
+			Topic{Name: "paused", Client: &stubStatusReporter{client.Status{Paused: true, LastReceived: time.Now().Add(-time.Hour)}}},
+		), time.Minute)
+		Convey("When readiness is requested", func() {
+			response := httptest.NewRecorder()
+			checker.HandleReadiness(response, httptest.NewRequest("GET", "/healthcheck/ready", nil))
+			status := checker.Check()
+			Convey("Then the topic should be reported as paused but ready", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(status.Topics[0].Ready, ShouldBeTrue)
+				So(status.Topics[0].Paused, ShouldBeTrue)
+				So(status.Topics[0].Connected, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestLiveness(t *testing.T) {
+	Convey("When liveness is requested", t, func() {
+		response := httptest.NewRecorder()
+		NewChecker(topics(), 0).HandleLiveness(response, httptest.NewRequest("GET", "/healthcheck", nil))
+		Convey("Then the service should be live", func() {
+			So(response.Code, ShouldEqual, http.StatusOK)
+		})
+	})
+}
@@ -57,7 +57,7 @@ func startContainer() testcontainers.Container {
 	envVariables.redisURL = fmt.Sprintf("%s:%s", redisHost, redisPort.Port())
 	envVariables.expiryInSeconds = 2
 
-	redisCacheService = cache.NewRedisCacheService("tcp", envVariables.redisURL, 10, envVariables.expiryInSeconds)
+	redisCacheService = cache.NewRedisCacheService("tcp", envVariables.redisURL, 10, envVariables.expiryInSeconds, 0, 0)
 
 	return redisC
 }
@@ -67,6 +67,14 @@ func stopContainer(container testcontainers.Container) {
 	container.Terminate(ctx)
 }
 
+func readDeltas(iterator cache.Iterator) ([]string, error) {
+	var deltas []string
+	for iterator.Next() {
+		deltas = append(deltas, iterator.Entry().Delta)
+	}
+	return deltas, iterator.Err()
+}
+
 func TestIntegrationRedisCacheService_Create(t *testing.T) {
 	Convey("When I create a cached entry", t, func() {
 		const topic = "stream:test"
@@ -87,7 +95,7 @@ func TestIntegrationRedisCacheService_Read(t *testing.T) {
 			t.Error("Failed: " + err.Error())
 		}
 		Convey("When I fetch the cached entries", func() {
-			actual, err := redisCacheService.Read(topic, 0)
+			actual, err := readDeltas(redisCacheService.Read(topic, 0))
 			if err != nil {
 				t.Error("Failed: " + err.Error())
 			}
@@ -111,7 +119,7 @@ func TestIntegrationRedisCacheService_ReadFromAGivenOffset(t *testing.T) {
 			}
 		}
 		Convey("When I fetch the cached entries for a given offset", func() {
-			actualArray, err := redisCacheService.Read(topic, 15)
+			actualArray, err := readDeltas(redisCacheService.Read(topic, 15))
 			if err != nil {
 				t.Error("Failed: " + err.Error())
 			}
@@ -141,7 +149,7 @@ func TestIntegrationRedisCacheService_ReadDoesNotReturnExpiredEntries(t *testing
 			fmt.Println("Waiting for cache entries to expire...")
 			time.Sleep(time.Duration(envVariables.expiryInSeconds) * time.Second)
 			Convey("Then the expired entries for the given offset should not be returned", func() {
-				actualArray, err := redisCacheService.Read(topic, 10)
+				actualArray, err := readDeltas(redisCacheService.Read(topic, 10))
 				if err != nil {
 					t.Error("Failed: " + err.Error())
 				}
@@ -150,3 +158,51 @@ func TestIntegrationRedisCacheService_ReadDoesNotReturnExpiredEntries(t *testing
 		})
 	})
 }
+
+func TestIntegrationRedisCacheService_LatestOffset(t *testing.T) {
+	Convey("Given entries exist in the redis cache for a topic", t, func() {
+		const topic = "stream:test5"
+		for _, score := range []int64{30, 32, 31} {
+			err := redisCacheService.Create(topic, fmt.Sprintf("{id : %d}", score), score)
+			if err != nil {
+				t.Error("Failed: " + err.Error())
+			}
+		}
+		Convey("When I fetch the latest offset", func() {
+			actual, err := redisCacheService.LatestOffset(topic)
+			if err != nil {
+				t.Error("Failed: " + err.Error())
+			}
+			Convey("Then the highest cached offset should be returned", func() {
+				So(actual, ShouldEqual, 32)
+			})
+		})
+		Convey("When I fetch the latest offset for a topic with no cached entries", func() {
+			actual, err := redisCacheService.LatestOffset("stream:empty")
+			Convey("Then zero should be returned", func() {
+				So(err, ShouldBeNil)
+				So(actual, ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestIntegrationRedisCacheService_OffsetRange(t *testing.T) {
+	Convey("Given entries exist in the redis cache for a topic", t, func() {
+		const topic = "stream:test6"
+		for score := 40; score < 45; score++ {
+			err := redisCacheService.Create(topic, fmt.Sprintf("{id : %d}", score), int64(score))
+			if err != nil {
+				t.Error("Failed: " + err.Error())
+			}
+		}
+		Convey("When I fetch the range of cached offsets", func() {
+			oldest, newest, err := redisCacheService.OffsetRange(topic)
+			Convey("Then the oldest and newest cached offsets should be returned", func() {
+				So(err, ShouldBeNil)
+				So(oldest, ShouldEqual, 40)
+				So(newest, ShouldEqual, 44)
+			})
+		})
+	})
+}
@@ -0,0 +1,117 @@
+// Package limits restricts the number of concurrent streams each consumer of a topic may open, and
+// the rate at which they may replay its history.
+package limits
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReplayBurst = 5
+	// How often the buckets of consumers that have not replayed recently are discarded.
+	sweepInterval = time.Minute
+)
+
+// A Limiter tracks the streams and replays of each consumer of a single topic. A zero limit
+// disables the corresponding check.
+type Limiter struct {
+	maxStreams  int
+	replayRate  float64
+	replayBurst float64
+	mutex       sync.Mutex
+	streams     map[string]int
+	buckets     map[string]*bucket
+	swept       time.Time
+	now         func() time.Time
+}
+
+// A token bucket of the replays a consumer may make.
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// Create a new Limiter allowing each consumer the given number of concurrent streams, and history
+// replays at the given rate per minute with bursts of up to replayBurst.
+func NewLimiter(maxStreams int, replaysPerMinute float64, replayBurst int) *Limiter {
+	if replayBurst <= 0 {
+		replayBurst = defaultReplayBurst
+	}
+	return &Limiter{
+		maxStreams:  maxStreams,
+		replayRate:  replaysPerMinute / 60,
+		replayBurst: float64(replayBurst),
+		streams:     make(map[string]int),
+		buckets:     make(map[string]*bucket),
+		now:         time.Now,
+	}
+}
+
+// Acquire a stream for the given consumer, returning false if it already has the maximum number
+// open. The returned function releases the stream once it has ended.
+func (l *Limiter) Acquire(consumer string) (func(), bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.maxStreams > 0 && l.streams[consumer] >= l.maxStreams {
+		return nil, false
+	}
+	l.streams[consumer]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.release(consumer)
+		})
+	}, true
+}
+
+func (l *Limiter) release(consumer string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.streams[consumer]--; l.streams[consumer] <= 0 {
+		delete(l.streams, consumer)
+	}
+}
+
+// AllowReplay takes a token from the given consumer's bucket of replays. If none is available it
+// returns false along with how long the consumer should wait before trying again.
+func (l *Limiter) AllowReplay(consumer string) (time.Duration, bool) {
+	if l.replayRate <= 0 {
+		return 0, true
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b, ok := l.buckets[consumer]
+	if !ok {
+		b = &bucket{tokens: l.replayBurst, updated: now}
+		l.buckets[consumer] = b
+	}
+	b.tokens = l.refill(b, now)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / l.replayRate * float64(time.Second)), false
+}
+
+// The tokens in a bucket at the given time.
+func (l *Limiter) refill(b *bucket, now time.Time) float64 {
+	return math.Min(l.replayBurst, b.tokens+now.Sub(b.updated).Seconds()*l.replayRate)
+}
+
+// Discard the buckets that have refilled, as they are no different to those of new consumers.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < sweepInterval {
+		return
+	}
+	l.swept = now
+	for consumer, b := range l.buckets {
+		if l.refill(b, now) >= l.replayBurst {
+			delete(l.buckets, consumer)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package limits
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+func TestUnitAcquire(t *testing.T) {
+	Convey("Given a limiter allowing two streams per consumer", t, func() {
+		limiter := NewLimiter(2, 0, 0)
+		first, ok := limiter.Acquire("consumer")
+		So(ok, ShouldBeTrue)
+		_, ok = limiter.Acquire("consumer")
+		So(ok, ShouldBeTrue)
+		Convey("When the consumer opens a third stream", func() {
+			_, ok := limiter.Acquire("consumer")
+			Convey("Then it should be refused, while other consumers are unaffected", func() {
+				So(ok, ShouldBeFalse)
+				_, ok = limiter.Acquire("other")
+				So(ok, ShouldBeTrue)
+			})
+		})
+		Convey("When one of the streams is released, even more than once", func() {
+			first()
+			first()
+			Convey("Then one more stream should be allowed", func() {
+				_, ok := limiter.Acquire("consumer")
+				So(ok, ShouldBeTrue)
+				_, ok = limiter.Acquire("consumer")
+				So(ok, ShouldBeFalse)
+			})
+		})
+	})
+}
+
+func TestUnitAllowReplay(t *testing.T) {
+	Convey("Given a limiter allowing 60 replays a minute in bursts of 2", t, func() {
+		now := time.Unix(1000, 0)
+		limiter := NewLimiter(0, 60, 2)
+		limiter.now = func() time.Time {
+			return now
+		}
+		Convey("When a consumer replays three times at once", func() {
+			_, first := limiter.AllowReplay("consumer")
+			_, second := limiter.AllowReplay("consumer")
+			retryAfter, third := limiter.AllowReplay("consumer")
+			Convey("Then the third should be refused until a token is available", func() {
+				So(first, ShouldBeTrue)
+				So(second, ShouldBeTrue)
+				So(third, ShouldBeFalse)
+				So(retryAfter, ShouldEqual, time.Second)
+			})
+			Convey("Then the consumer should be allowed to replay again once a token is available", func() {
+				now = now.Add(time.Second)
+				_, ok := limiter.AllowReplay("consumer")
+				So(ok, ShouldBeTrue)
+			})
+		})
+	})
+	Convey("Given a limiter without a replay rate", t, func() {
+		limiter := NewLimiter(0, 0, 0)
+		Convey("Then replays should always be allowed", func() {
+			for i := 0; i < 100; i++ {
+				_, ok := limiter.AllowReplay("consumer")
+				So(ok, ShouldBeTrue)
+			}
+		})
+	})
+}
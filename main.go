@@ -1,24 +1,26 @@
 package main
 
 import (
+	"context"
+	"github.com/companieshouse/chs-streaming-api-cache/admin"
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
 	"github.com/companieshouse/chs-streaming-api-cache/config"
+	"github.com/companieshouse/chs-streaming-api-cache/health"
+	"github.com/companieshouse/chs-streaming-api-cache/logger"
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
 	"github.com/companieshouse/chs-streaming-api-cache/service"
 	chslog "github.com/companieshouse/chs.go/log"
 	chsservice "github.com/companieshouse/chs.go/service"
 	"github.com/companieshouse/chs.go/service/handlers/requestID"
 	"github.com/justinas/alice"
-	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-const (
-	filingHistoryStream     = "stream-filing-history"
-	companyProfileStream    = "stream-company-profile"
-	companyInsolvencyStream = "stream-company-insolvency"
-	companyChargesStream    = "stream-company-charges"
-	companyOfficersStream   = "stream-company-officers"
-	companyPSCStream        = "stream-company-psc"
-	servicePrefix           = "/streaming-api-cache"
-)
+const defaultShutdownTimeout = 10 * time.Second
 
 func main() {
 	chsservice.DefaultMiddleware = []alice.Constructor{requestID.Handler(20), chslog.Handler}
@@ -29,20 +31,72 @@ func main() {
 	}
 	svc := chsservice.New(config.ServiceConfig())
 
+	topics, err := registry.Load(config)
+	if err != nil {
+		panic(err)
+	}
+
 	cacheConfiguration := &service.CacheConfiguration{
 		Configuration: config,
-		Router:        svc.Router(),
+		Registry:      topics,
 	}
+	if config.ApiKeysFile != "" {
+		keyStore, err := auth.NewFileKeyStore(config.ApiKeysFile)
+		if err != nil {
+			panic(err)
+		}
+		cacheConfiguration.Authenticator = auth.NewAuthenticator(keyStore, logger.NewLogger())
+	} else {
+		chslog.Info("No API_KEYS_FILE configured, the streams are not authenticated")
+	}
+
+	manager := service.NewManager(cacheConfiguration)
+	for _, topic := range topics.Topics {
+		if _, err := manager.Add(topic); err != nil {
+			panic(err)
+		}
+	}
+
+	checker := health.NewChecker(manager.Health, time.Duration(config.StalenessWindowInSeconds)*time.Second)
+
+	svc.Router().Path("/metrics").Methods("GET").Handler(metrics.Handler())
+	svc.Router().Path("/healthcheck/ready").Methods("GET").HandlerFunc(checker.HandleReadiness)
+	svc.Router().Path("/healthcheck").Methods("GET").HandlerFunc(checker.HandleLiveness)
+	if cacheConfiguration.Authenticator != nil {
+		admin.NewHandler(manager, logger.NewLogger()).Register(svc.Router(), cacheConfiguration.Authenticator)
+	} else {
+		chslog.Info("No API_KEYS_FILE configured, the admin API is disabled")
+	}
+	// The topics are served by the manager, so that they can be added and removed at runtime.
+	svc.Router().PathPrefix("/").Handler(manager)
+
+	stopped := make(chan struct{})
+	go func() {
+		svc.Start()
+		close(stopped)
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	select {
+	case sig := <-signals:
+		chslog.Info("Shutting down", chslog.Data{"signal": sig.String()})
+	case <-stopped:
+		chslog.Info("Server stopped, shutting down")
+	}
+
+	timeout := time.Duration(config.ShutdownTimeoutInSeconds) * time.Second
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	shutdown(manager, timeout)
+}
 
-	service.NewCacheService(cacheConfiguration).WithTopic(filingHistoryStream).WithPath(servicePrefix + "/filings").Initialise().Start()
-	service.NewCacheService(cacheConfiguration).WithTopic(companyProfileStream).WithPath(servicePrefix + "/companies").Initialise().Start()
-	service.NewCacheService(cacheConfiguration).WithTopic(companyInsolvencyStream).WithPath(servicePrefix + "/insolvency-cases").Initialise().Start()
-	service.NewCacheService(cacheConfiguration).WithTopic(companyChargesStream).WithPath(servicePrefix + "/charges").Initialise().Start()
-	service.NewCacheService(cacheConfiguration).WithTopic(companyOfficersStream).WithPath(servicePrefix + "/officers").Initialise().Start()
-	service.NewCacheService(cacheConfiguration).WithTopic(companyPSCStream).WithPath(servicePrefix + "/persons-with-significant-control").Initialise().Start()
+// Shutdown all topics, giving up once the timeout has elapsed.
+func shutdown(manager *service.Manager, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	svc.Router().Path("/healthcheck").Methods("GET").HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-	})
-	svc.Start()
+	manager.Shutdown(ctx)
+	chslog.Info("Shutdown complete")
 }
@@ -3,16 +3,7 @@ package mapper
 import (
 	"fmt"
 	"github.com/companieshouse/chs-streaming-api-cache/config"
-)
-
-const (
-	servicePrefix     = "/streaming-api-cache"
-	FilingHistoryPath = servicePrefix + "/filings"
-	CompaniesPath     = servicePrefix + "/companies"
-	InsolvencyPath    = servicePrefix + "/insolvency-cases"
-	ChargesPath       = servicePrefix + "/charges"
-	OfficersPath      = servicePrefix + "/officers"
-	PSCsPath          = servicePrefix + "/persons-with-significant-control"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
 )
 
 // A topic mapper that obtains topics for the specified resource kind from the app configuration model
@@ -23,16 +14,14 @@ type ConfigurationPathMapper struct {
 
 // Create a new ConfigurationPathMapper instance with all backend path mappings resolved from configuration
 func New(cfg *config.Config) *ConfigurationPathMapper {
-	mapper := &ConfigurationPathMapper{}
-	mapper.Paths = map[string]string{
-		FilingHistoryPath: cfg.StreamFilingsPath,
-		CompaniesPath:     cfg.StreamCompaniesPath,
-		InsolvencyPath:    cfg.StreamInsolvencyPath,
-		ChargesPath:       cfg.StreamChargesPath,
-		OfficersPath:      cfg.StreamOfficersPath,
-		PSCsPath:          cfg.StreamPSCsPath,
+	return NewFromRegistry(registry.FromConfig(cfg))
+}
+
+// Create a new ConfigurationPathMapper instance with the backend path mappings of the topics in a registry
+func NewFromRegistry(topics *registry.Registry) *ConfigurationPathMapper {
+	return &ConfigurationPathMapper{
+		Paths: topics.BackendPaths(),
 	}
-	return mapper
 }
 
 // Obtain a backend path corresponding to the given stream path
@@ -0,0 +1,149 @@
+// Package metrics exposes Prometheus metrics describing ingestion, caching and subscribers for each topic.
+package metrics
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const namespace = "chs_streaming_api_cache"
+
+var (
+	// DeltasIngested counts the deltas received from the backend and cached, by topic.
+	DeltasIngested = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "deltas_ingested_total",
+		Help:      "Number of deltas received from the backend and cached.",
+	}, []string{"topic"})
+
+	// BackendReconnects counts the attempts made to re-establish the backend stream, by topic.
+	BackendReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backend_reconnects_total",
+		Help:      "Number of attempts made to re-establish the backend stream.",
+	}, []string{"topic"})
+
+	// LatestOffset records the most recent offset cached, by topic.
+	LatestOffset = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "latest_offset",
+		Help:      "The most recent offset cached.",
+	}, []string{"topic"})
+
+	// CacheDuration records the latency of cache operations, by topic and operation.
+	CacheDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cache_operation_duration_seconds",
+		Help:      "Latency of cache operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"topic", "operation"})
+
+	// CacheErrors counts failed cache operations, by topic and operation.
+	CacheErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_operation_errors_total",
+		Help:      "Number of failed cache operations.",
+	}, []string{"topic", "operation"})
+
+	// CacheEntriesPruned counts the expired or excess offsets removed from the cache index, by topic.
+	CacheEntriesPruned = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_entries_pruned_total",
+		Help:      "Number of expired or excess offsets removed from the cache index.",
+	}, []string{"topic"})
+
+	// CacheReads counts the reads of each tier of a tiered cache, by topic, tier and whether the tier
+	// held any of the offsets requested.
+	CacheReads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_reads_total",
+		Help:      "Number of reads of each tier of a tiered cache, by whether the tier held the offsets requested.",
+	}, []string{"topic", "tier", "result"})
+
+	// RequestsLimited counts the requests and replays refused for exceeding a consumer's limits, by
+	// topic and the limit exceeded.
+	RequestsLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_limited_total",
+		Help:      "Number of requests and replays refused for exceeding a consumer's limits.",
+	}, []string{"topic", "limit"})
+
+	brokers = &brokerCollector{brokers: make(map[string]StatsProvider)}
+)
+
+func init() {
+	prometheus.MustRegister(DeltasIngested, BackendReconnects, LatestOffset, CacheDuration, CacheErrors, CacheEntriesPruned, CacheReads, RequestsLimited, brokers)
+}
+
+// Handler returns the handler serving all registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveCacheOperation records the latency of a cache operation that started at the given time,
+// counting it as an error if err is not nil.
+func ObserveCacheOperation(topic string, operation string, start time.Time, err error) {
+	CacheDuration.WithLabelValues(topic, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		CacheErrors.WithLabelValues(topic, operation).Inc()
+	}
+}
+
+// A StatsProvider reports the subscriber and message counters for a topic.
+type StatsProvider interface {
+	Stats() broker.Stats
+}
+
+// RegisterBroker exposes the counters of the given broker under its topic.
+func RegisterBroker(topic string, provider StatsProvider) {
+	brokers.mutex.Lock()
+	defer brokers.mutex.Unlock()
+	brokers.brokers[topic] = provider
+}
+
+// UnregisterBroker stops exposing the counters for the given topic.
+func UnregisterBroker(topic string) {
+	brokers.mutex.Lock()
+	defer brokers.mutex.Unlock()
+	delete(brokers.brokers, topic)
+}
+
+var (
+	subscribersDesc = prometheus.NewDesc(namespace+"_subscribers",
+		"Number of users currently subscribed.", []string{"topic"}, nil)
+	publishedDesc = prometheus.NewDesc(namespace+"_messages_published_total",
+		"Number of messages published to subscribers.", []string{"topic"}, nil)
+	droppedDesc = prometheus.NewDesc(namespace+"_messages_dropped_total",
+		"Number of messages dropped for subscribers that had fallen behind.", []string{"topic"}, nil)
+	disconnectedDesc = prometheus.NewDesc(namespace+"_subscribers_disconnected_total",
+		"Number of subscribers disconnected for falling behind.", []string{"topic"}, nil)
+)
+
+// A collector reading the counters maintained by each broker when metrics are scraped.
+type brokerCollector struct {
+	mutex   sync.RWMutex
+	brokers map[string]StatsProvider
+}
+
+func (c *brokerCollector) Describe(descs chan<- *prometheus.Desc) {
+	descs <- subscribersDesc
+	descs <- publishedDesc
+	descs <- droppedDesc
+	descs <- disconnectedDesc
+}
+
+func (c *brokerCollector) Collect(metrics chan<- prometheus.Metric) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for topic, provider := range c.brokers {
+		stats := provider.Stats()
+		metrics <- prometheus.MustNewConstMetric(subscribersDesc, prometheus.GaugeValue, float64(stats.Subscribers), topic)
+		metrics <- prometheus.MustNewConstMetric(publishedDesc, prometheus.CounterValue, float64(stats.Published), topic)
+		metrics <- prometheus.MustNewConstMetric(droppedDesc, prometheus.CounterValue, float64(stats.Dropped), topic)
+		metrics <- prometheus.MustNewConstMetric(disconnectedDesc, prometheus.CounterValue, float64(stats.Disconnected), topic)
+	}
+}
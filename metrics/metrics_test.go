@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+type stubStatsProvider struct {
+	stats broker.Stats
+}
+
+func (s *stubStatsProvider) Stats() broker.Stats {
+	return s.stats
+}
+
+func TestCollectBrokerStats(t *testing.T) {
+	Convey("Given a broker has been registered for a topic", t, func() {
+		RegisterBroker("topic", &stubStatsProvider{broker.Stats{Subscribers: 3, Published: 10, Dropped: 2, Disconnected: 1}})
+		Reset(func() {
+			UnregisterBroker("topic")
+		})
+		Convey("When metrics are collected", func() {
+			expected := `
+# HELP chs_streaming_api_cache_subscribers Number of users currently subscribed.
+# TYPE chs_streaming_api_cache_subscribers gauge
+chs_streaming_api_cache_subscribers{topic="topic"} 3
+# HELP chs_streaming_api_cache_messages_dropped_total Number of messages dropped for subscribers that had fallen behind.
+# TYPE chs_streaming_api_cache_messages_dropped_total counter
+chs_streaming_api_cache_messages_dropped_total{topic="topic"} 2
+`
+			err := testutil.CollectAndCompare(brokers, strings.NewReader(expected),
+				"chs_streaming_api_cache_subscribers", "chs_streaming_api_cache_messages_dropped_total")
+			Convey("Then the broker's counters should be reported under its topic", func() {
+				So(err, ShouldBeNil)
+			})
+		})
+		Convey("When the broker is unregistered", func() {
+			UnregisterBroker("topic")
+			Convey("Then no metrics should be reported for it", func() {
+				So(testutil.CollectAndCount(brokers), ShouldEqual, 0)
+			})
+		})
+	})
+}
+
+func TestObserveCacheOperationCountsErrors(t *testing.T) {
+	Convey("When a failed cache operation is observed", t, func() {
+		before := testutil.ToFloat64(CacheErrors.WithLabelValues("errors", "read"))
+		ObserveCacheOperation("errors", "read", time.Now(), errors.New("failed"))
+		Convey("Then the error counter for the topic and operation should be incremented", func() {
+			So(testutil.ToFloat64(CacheErrors.WithLabelValues("errors", "read")), ShouldEqual, before+1)
+		})
+	})
+}
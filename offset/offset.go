@@ -20,6 +20,7 @@ type Offset struct {
 // Interface - interface for offset methods
 type Interface interface {
 	Parse(offset string) (int64, error)
+	Validate(offset int64, oldest int64, newest int64) error
 }
 
 // NewOffset - return a new Offset
@@ -43,3 +44,16 @@ func (of *Offset) Parse(offset string) (int64, error) {
 
 	return o, nil
 }
+
+// Validate checks that an offset can be served from a cache holding the offsets from oldest to
+// newest. The offset after the newest is valid, as it is the next to be published. If nothing has
+// been cached, so that both oldest and newest are zero, any offset is accepted.
+func (of *Offset) Validate(offset int64, oldest int64, newest int64) error {
+	if oldest == 0 && newest == 0 {
+		return nil
+	}
+	if offset < oldest || offset > newest+1 {
+		return ErrOutOfRange
+	}
+	return nil
+}
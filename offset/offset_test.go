@@ -33,3 +33,26 @@ func TestUnitOffset(t *testing.T) {
 		So(offset, ShouldEqual, 5)
 	})
 }
+
+func TestUnitValidate(t *testing.T) {
+	Convey("Test Validate accepts offsets within the cached range", t, func() {
+		So(offsetManager.Validate(10, 10, 20), ShouldBeNil)
+		So(offsetManager.Validate(20, 10, 20), ShouldBeNil)
+	})
+
+	Convey("Test Validate accepts the offset after the newest cached", t, func() {
+		So(offsetManager.Validate(21, 10, 20), ShouldBeNil)
+	})
+
+	Convey("Test Validate returns err out of range if offset is older than the oldest cached", t, func() {
+		So(offsetManager.Validate(9, 10, 20), ShouldEqual, ErrOutOfRange)
+	})
+
+	Convey("Test Validate returns err out of range if offset is beyond the newest cached", t, func() {
+		So(offsetManager.Validate(22, 10, 20), ShouldEqual, ErrOutOfRange)
+	})
+
+	Convey("Test Validate accepts any offset if nothing has been cached", t, func() {
+		So(offsetManager.Validate(5, 0, 0), ShouldBeNil)
+	})
+}
@@ -0,0 +1,112 @@
+// Package projection strips the deltas streamed to users down to the fields they ask for.
+package projection
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// A Projection selects fields from a delta. Each key is a field selected, holding the projection
+// of its value, or nil if the whole value is selected.
+type Projection map[string]Projection
+
+// Parse a comma separated list of field selectors, such as resource_id,event.timepoint, where each
+// selector is a dot separated path to a field that may begin with $. as in JSONPath.
+func Parse(fields string) (Projection, error) {
+	projection := Projection{}
+	for _, selector := range strings.Split(fields, ",") {
+		selector = strings.TrimPrefix(strings.TrimSpace(selector), "$.")
+		path := strings.Split(selector, ".")
+		for _, name := range path {
+			if name == "" {
+				return nil, fmt.Errorf("invalid field selector [%s]", selector)
+			}
+		}
+		projection.add(path)
+	}
+	return projection, nil
+}
+
+// Add the field at the given path to this projection. A field already selected as a whole is
+// unaffected by selecting the fields within it.
+func (p Projection) add(path []string) {
+	child, ok := p[path[0]]
+	if len(path) == 1 {
+		p[path[0]] = nil
+		return
+	}
+	if ok && child == nil {
+		return
+	}
+	if child == nil {
+		child = Projection{}
+		p[path[0]] = child
+	}
+	child.add(path[1:])
+}
+
+// Apply this projection to a delta, omitting any fields selected that it does not have. Each
+// element of an array is projected in the same way.
+func (p Projection) Apply(data string) (string, error) {
+	projected, ok, err := p.apply(json.RawMessage(data))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "{}", nil
+	}
+	return string(projected), nil
+}
+
+func (p Projection) apply(value json.RawMessage) (json.RawMessage, bool, error) {
+	if p == nil {
+		return value, true, nil
+	}
+	var elements []json.RawMessage
+	if err := json.Unmarshal(value, &elements); err == nil {
+		return p.applyToArray(elements)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(value, &fields); err != nil {
+		if _, ok := err.(*json.UnmarshalTypeError); ok {
+			// a scalar, which has none of the fields selected within it
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	projected := make(map[string]json.RawMessage)
+	for name, child := range p {
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		value, ok, err := child.apply(field)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			projected[name] = value
+		}
+	}
+	if len(projected) == 0 {
+		return nil, false, nil
+	}
+	result, err := json.Marshal(projected)
+	return result, err == nil, err
+}
+
+func (p Projection) applyToArray(elements []json.RawMessage) (json.RawMessage, bool, error) {
+	projected := make([]json.RawMessage, 0, len(elements))
+	for _, element := range elements {
+		value, ok, err := p.apply(element)
+		if err != nil {
+			return nil, false, err
+		}
+		if ok {
+			projected = append(projected, value)
+		}
+	}
+	result, err := json.Marshal(projected)
+	return result, err == nil, err
+}
@@ -0,0 +1,72 @@
+package projection
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+const officer = `{"resource_id":"abc","resource_kind":"company-officers","data":{"name":"SMITH, John","address":{"locality":"Cardiff","postal_code":"CF14 3UZ"},"links":[{"self":"/a","extra":1},{"self":"/b"}]},"event":{"timepoint":42,"type":"changed"}}`
+
+func TestUnitParse(t *testing.T) {
+	Convey("When field selectors are parsed", t, func() {
+		actual, err := Parse("resource_id, $.event.timepoint,data,data.name")
+		Convey("Then a projection of the fields should be returned", func() {
+			So(err, ShouldBeNil)
+			So(actual, ShouldResemble, Projection{
+				"resource_id": nil,
+				"event":       Projection{"timepoint": nil},
+				"data":        nil,
+			})
+		})
+	})
+	Convey("When an empty field selector is parsed", t, func() {
+		_, err := Parse("resource_id,event..timepoint")
+		Convey("Then an error should be returned", func() {
+			So(err.Error(), ShouldEqual, "invalid field selector [event..timepoint]")
+		})
+	})
+}
+
+func TestUnitApply(t *testing.T) {
+	Convey("Given an officer delta", t, func() {
+		Convey("When top level and nested fields are selected", func() {
+			projection, _ := Parse("resource_id,event.timepoint,data.address.locality")
+			actual, err := projection.Apply(officer)
+			Convey("Then only those fields should remain", func() {
+				So(err, ShouldBeNil)
+				So(actual, ShouldEqual, `{"data":{"address":{"locality":"Cardiff"}},"event":{"timepoint":42},"resource_id":"abc"}`)
+			})
+		})
+		Convey("When fields within an array are selected", func() {
+			projection, _ := Parse("data.links.self")
+			actual, err := projection.Apply(officer)
+			Convey("Then the fields should be selected from each element", func() {
+				So(err, ShouldBeNil)
+				So(actual, ShouldEqual, `{"data":{"links":[{"self":"/a"},{"self":"/b"}]}}`)
+			})
+		})
+		Convey("When missing fields are selected", func() {
+			projection, _ := Parse("resource_id,data.missing,event.timepoint.value,missing")
+			actual, err := projection.Apply(officer)
+			Convey("Then they should be omitted", func() {
+				So(err, ShouldBeNil)
+				So(actual, ShouldEqual, `{"resource_id":"abc"}`)
+			})
+		})
+		Convey("When no field selected is present", func() {
+			projection, _ := Parse("missing")
+			actual, err := projection.Apply(officer)
+			Convey("Then an empty object should be returned", func() {
+				So(err, ShouldBeNil)
+				So(actual, ShouldEqual, "{}")
+			})
+		})
+	})
+	Convey("When a projection is applied to a delta that is not JSON", t, func() {
+		projection, _ := Parse("resource_id")
+		_, err := projection.Apply("Hello world")
+		Convey("Then an error should be returned", func() {
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,119 @@
+// Package registry declares the topics streamed by the service, each with its public path, the
+// backend path it is ingested from and any options overriding the service-wide configuration.
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/config"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const servicePrefix = "/streaming-api-cache"
+
+// A Topic streamed by the service. Options left at zero fall back to the service-wide configuration.
+type Topic struct {
+	Name                 string `json:"name"                              yaml:"name"`
+	Path                 string `json:"path"                              yaml:"path"`
+	BackendPath          string `json:"backend_path"                      yaml:"backend_path"`
+	CacheExpiryInSeconds int64  `json:"cache_expiry_in_seconds,omitempty" yaml:"cache_expiry_in_seconds"`
+	CacheMaxEntries      int64  `json:"cache_max_entries,omitempty"       yaml:"cache_max_entries"`
+	SubscriberBufferSize int    `json:"subscriber_buffer_size,omitempty"  yaml:"subscriber_buffer_size"`
+}
+
+// A Registry of the topics streamed by the service.
+type Registry struct {
+	Topics []*Topic `json:"topics" yaml:"topics"`
+}
+
+// Load the registry from the file configured in TOPICS_FILE, or if none is configured build it
+// from the backend paths configured for each of the standard topics. The registry is validated
+// before it is returned.
+func Load(cfg *config.Config) (*Registry, error) {
+	registry := FromConfig(cfg)
+	if cfg.TopicsFile != "" {
+		var err error
+		if registry, err = FromFile(cfg.TopicsFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := registry.Validate(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Read a registry from a YAML file, if its name ends in .yaml or .yml, or otherwise a JSON file.
+// Either is rejected if it declares an option that is not known, so that a misspelt option is not
+// silently left at its default.
+func FromFile(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	registry := &Registry{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, registry)
+	default:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(registry)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read topics from [%s]: %w", path, err)
+	}
+	return registry, nil
+}
+
+// Build the registry of the standard topics from the backend paths configured for each.
+func FromConfig(cfg *config.Config) *Registry {
+	return &Registry{Topics: []*Topic{
+		{Name: "stream-filing-history", Path: servicePrefix + "/filings", BackendPath: cfg.StreamFilingsPath},
+		{Name: "stream-company-profile", Path: servicePrefix + "/companies", BackendPath: cfg.StreamCompaniesPath},
+		{Name: "stream-company-insolvency", Path: servicePrefix + "/insolvency-cases", BackendPath: cfg.StreamInsolvencyPath},
+		{Name: "stream-company-charges", Path: servicePrefix + "/charges", BackendPath: cfg.StreamChargesPath},
+		{Name: "stream-company-officers", Path: servicePrefix + "/officers", BackendPath: cfg.StreamOfficersPath},
+		{Name: "stream-company-psc", Path: servicePrefix + "/persons-with-significant-control", BackendPath: cfg.StreamPSCsPath},
+	}}
+}
+
+// Validate checks the registry has at least one topic, that every topic has a name, a path
+// beginning with / and a backend path, and that no name or path is used more than once.
+func (r *Registry) Validate() error {
+	if len(r.Topics) == 0 {
+		return fmt.Errorf("no topics registered")
+	}
+	names := make(map[string]bool)
+	paths := make(map[string]bool)
+	for i, topic := range r.Topics {
+		switch {
+		case topic.Name == "":
+			return fmt.Errorf("topic [%d] has no name", i)
+		case !strings.HasPrefix(topic.Path, "/"):
+			return fmt.Errorf("topic [%s] has no path beginning with /", topic.Name)
+		case topic.BackendPath == "":
+			return fmt.Errorf("topic [%s] has no backend path", topic.Name)
+		case names[topic.Name]:
+			return fmt.Errorf("topic [%s] is registered more than once", topic.Name)
+		case paths[topic.Path]:
+			return fmt.Errorf("path [%s] is registered for more than one topic", topic.Path)
+		}
+		names[topic.Name] = true
+		paths[topic.Path] = true
+	}
+	return nil
+}
+
+// BackendPaths returns the backend path of each topic by its public path.
+func (r *Registry) BackendPaths() map[string]string {
+	paths := make(map[string]string, len(r.Topics))
+	for _, topic := range r.Topics {
+		paths[topic.Path] = topic.BackendPath
+	}
+	return paths
+}
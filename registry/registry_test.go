@@ -0,0 +1,100 @@
+package registry
+
+import (
+	"github.com/companieshouse/chs-streaming-api-cache/config"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnitFromFile(t *testing.T) {
+	Convey("Given topics declared in YAML and JSON files", t, func() {
+		dir := t.TempDir()
+		yamlFile := filepath.Join(dir, "topics.yaml")
+		So(os.WriteFile(yamlFile, []byte("topics:\n  - name: stream-filing-history\n    path: /streaming-api-cache/filings\n    backend_path: /backend/filings\n    cache_max_entries: 1000\n"), 0600), ShouldBeNil)
+		jsonFile := filepath.Join(dir, "topics.json")
+		So(os.WriteFile(jsonFile, []byte(`{"topics":[{"name":"stream-filing-history","path":"/streaming-api-cache/filings","backend_path":"/backend/filings","cache_max_entries":1000}]}`), 0600), ShouldBeNil)
+		expected := &Registry{Topics: []*Topic{{
+			Name:            "stream-filing-history",
+			Path:            "/streaming-api-cache/filings",
+			BackendPath:     "/backend/filings",
+			CacheMaxEntries: 1000,
+		}}}
+		Convey("When the registries are read", func() {
+			fromYAML, yamlErr := FromFile(yamlFile)
+			fromJSON, jsonErr := FromFile(jsonFile)
+			Convey("Then both should hold the declared topics", func() {
+				So(yamlErr, ShouldBeNil)
+				So(jsonErr, ShouldBeNil)
+				So(fromYAML, ShouldResemble, expected)
+				So(fromJSON, ShouldResemble, expected)
+			})
+		})
+	})
+}
+
+func TestUnitFromFileRejectsUnknownOptions(t *testing.T) {
+	Convey("Given topics declared in YAML and JSON files with a misspelt option", t, func() {
+		dir := t.TempDir()
+		yamlFile := filepath.Join(dir, "topics.yml")
+		So(os.WriteFile(yamlFile, []byte("topics:\n  - name: stream-filing-history\n    path: /streaming-api-cache/filings\n    backend_path: /backend/filings\n    cache_max_entry: 1000\n"), 0600), ShouldBeNil)
+		jsonFile := filepath.Join(dir, "topics.json")
+		So(os.WriteFile(jsonFile, []byte(`{"topics":[{"name":"stream-filing-history","path":"/streaming-api-cache/filings","backend_path":"/backend/filings","cache_max_entry":1000}]}`), 0600), ShouldBeNil)
+		Convey("When the registries are read", func() {
+			_, yamlErr := FromFile(yamlFile)
+			_, jsonErr := FromFile(jsonFile)
+			Convey("Then both should be rejected", func() {
+				So(yamlErr, ShouldNotBeNil)
+				So(jsonErr, ShouldNotBeNil)
+				So(jsonErr.Error(), ShouldContainSubstring, "cache_max_entry")
+			})
+		})
+	})
+}
+
+func TestUnitLoadFromConfig(t *testing.T) {
+	Convey("Given the backend paths of the standard topics are configured", t, func() {
+		cfg := &config.Config{
+			StreamFilingsPath:    "/backend/filings",
+			StreamCompaniesPath:  "/backend/companies",
+			StreamInsolvencyPath: "/backend/insolvency-cases",
+			StreamChargesPath:    "/backend/charges",
+			StreamOfficersPath:   "/backend/officers",
+			StreamPSCsPath:       "/backend/pscs",
+		}
+		Convey("When the registry is loaded without a topics file", func() {
+			registry, err := Load(cfg)
+			Convey("Then it should hold the six standard topics", func() {
+				So(err, ShouldBeNil)
+				So(registry.Topics, ShouldHaveLength, 6)
+				So(registry.BackendPaths()["/streaming-api-cache/filings"], ShouldEqual, "/backend/filings")
+			})
+		})
+		Convey("When a backend path is missing", func() {
+			cfg.StreamPSCsPath = ""
+			_, err := Load(cfg)
+			Convey("Then an error should be returned", func() {
+				So(err.Error(), ShouldEqual, "topic [stream-company-psc] has no backend path")
+			})
+		})
+	})
+}
+
+func TestUnitValidate(t *testing.T) {
+	Convey("Given registries with duplicated topics", t, func() {
+		duplicateName := &Registry{Topics: []*Topic{
+			{Name: "topic", Path: "/one", BackendPath: "/backend/one"},
+			{Name: "topic", Path: "/two", BackendPath: "/backend/two"},
+		}}
+		duplicatePath := &Registry{Topics: []*Topic{
+			{Name: "one", Path: "/path", BackendPath: "/backend/one"},
+			{Name: "two", Path: "/path", BackendPath: "/backend/two"},
+		}}
+		Convey("Then validation should fail", func() {
+			So(duplicateName.Validate().Error(), ShouldEqual, "topic [topic] is registered more than once")
+			So(duplicatePath.Validate().Error(), ShouldEqual, "path [/path] is registered for more than one topic")
+			So((&Registry{}).Validate().Error(), ShouldEqual, "no topics registered")
+		})
+	})
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/health"
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
+	chslog "github.com/companieshouse/chs.go/log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The states of a topic.
+const (
+	StateRunning = "running"
+	StatePaused  = "paused"
+)
+
+var (
+	// ErrTopicNotFound is the error returned when managing a topic that is not being streamed.
+	ErrTopicNotFound = errors.New("topic not found")
+	// ErrTopicExists is the error returned when adding a topic with the name or path of one already
+	// being streamed.
+	ErrTopicExists = errors.New("topic already exists")
+	// ErrCacheUnavailable is the error returned when adding a topic whose cache cannot be created,
+	// such as when Redis cannot be reached.
+	ErrCacheUnavailable = errors.New("cache unavailable")
+)
+
+// The state of a topic being streamed.
+type TopicState struct {
+	Name         string     `json:"name"`
+	Path         string     `json:"path"`
+	BackendPath  string     `json:"backend_path"`
+	State        string     `json:"state"`
+	Connected    bool       `json:"connected"`
+	Offset       int64      `json:"offset"`
+	LastReceived *time.Time `json:"last_received,omitempty"`
+	Subscribers  int        `json:"subscribers"`
+}
+
+// A user subscribed to a topic.
+type SubscriberState struct {
+	ID            string    `json:"id"`
+	RemoteAddress string    `json:"remote_address"`
+	Identity      string    `json:"identity,omitempty"`
+	RequestID     string    `json:"request_id,omitempty"`
+	StartedAt     time.Time `json:"started_at"`
+	Timepoint     int64     `json:"timepoint,omitempty"`
+	MessagesSent  uint64    `json:"messages_sent"`
+	BytesSent     uint64    `json:"bytes_sent"`
+}
+
+// A Manager starts, pauses, resumes and removes the cache services of the streamed topics while
+// the service is running, and serves each topic's stream at its path.
+type Manager struct {
+	cfg      *CacheConfiguration
+	mutex    sync.RWMutex
+	services map[string]*CacheService
+	paths    map[string]*CacheService
+	// The paths of the topics being added, by name, reserved while their cache services are created
+	adding map[string]string
+}
+
+// Create a new Manager building the cache services of its topics from the given configuration.
+// Topics are served by the manager rather than registered with the configured router.
+func NewManager(cfg *CacheConfiguration) *Manager {
+	managed := *cfg
+	managed.Router = nil
+	return &Manager{
+		cfg:      &managed,
+		services: make(map[string]*CacheService),
+		paths:    make(map[string]*CacheService),
+		adding:   make(map[string]string),
+	}
+}
+
+// Add a topic, starting its cache service. Its name and path are reserved while the cache service
+// is created, which may take until Redis can be dialled, so that other topics are served meanwhile.
+func (m *Manager) Add(topic *registry.Topic) (TopicState, error) {
+	if err := (&registry.Registry{Topics: []*registry.Topic{topic}}).Validate(); err != nil {
+		return TopicState{}, err
+	}
+	if err := m.reserve(topic); err != nil {
+		return TopicState{}, err
+	}
+	cacheService, err := NewCacheService(m.cfg).ForTopic(topic).Initialise()
+	if err != nil {
+		m.mutex.Lock()
+		delete(m.adding, topic.Name)
+		m.mutex.Unlock()
+		return TopicState{}, fmt.Errorf("%w: %v", ErrCacheUnavailable, err)
+	}
+	cacheService.Start()
+	m.mutex.Lock()
+	delete(m.adding, topic.Name)
+	m.services[topic.Name] = cacheService
+	m.paths[topic.Path] = cacheService
+	m.mutex.Unlock()
+	chslog.Info("Topic added", chslog.Data{"topic": topic.Name, "path": topic.Path})
+	return cacheService.State(), nil
+}
+
+// Reserve the name and path of a topic being added, returning ErrTopicExists if either is already
+// used by a topic being streamed or added.
+func (m *Manager) reserve(topic *registry.Topic) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.services[topic.Name]; ok {
+		return ErrTopicExists
+	}
+	if _, ok := m.adding[topic.Name]; ok {
+		return ErrTopicExists
+	}
+	if _, ok := m.paths[topic.Path]; ok {
+		return ErrTopicExists
+	}
+	for _, path := range m.adding {
+		if path == topic.Path {
+			return ErrTopicExists
+		}
+	}
+	m.adding[topic.Name] = topic.Path
+	return nil
+}
+
+// Pause ingestion of the given topic.
+func (m *Manager) Pause(name string) (TopicState, error) {
+	cacheService, err := m.get(name)
+	if err != nil {
+		return TopicState{}, err
+	}
+	cacheService.Pause()
+	chslog.Info("Topic paused", chslog.Data{"topic": name})
+	return cacheService.State(), nil
+}
+
+// Resume ingestion of the given topic.
+func (m *Manager) Resume(name string) (TopicState, error) {
+	cacheService, err := m.get(name)
+	if err != nil {
+		return TopicState{}, err
+	}
+	cacheService.Resume()
+	chslog.Info("Topic resumed", chslog.Data{"topic": name})
+	return cacheService.State(), nil
+}
+
+// Remove the given topic, shutting down its cache service. Its users are sent an end of stream
+// marker, and the topic stops being served immediately, before they have been disconnected.
+func (m *Manager) Remove(ctx context.Context, name string) error {
+	m.mutex.Lock()
+	cacheService, ok := m.services[name]
+	if ok {
+		// Unregistered before the name is freed, so as not to unregister a topic added in its place.
+		metrics.UnregisterBroker(name)
+		delete(m.services, name)
+		delete(m.paths, cacheService.path)
+	}
+	m.mutex.Unlock()
+	if !ok {
+		return ErrTopicNotFound
+	}
+	err := cacheService.Shutdown(ctx)
+	chslog.Info("Topic removed", chslog.Data{"topic": name})
+	return err
+}
+
+// Topic returns the state of the given topic.
+func (m *Manager) Topic(name string) (TopicState, error) {
+	cacheService, err := m.get(name)
+	if err != nil {
+		return TopicState{}, err
+	}
+	return cacheService.State(), nil
+}
+
+// Subscribers returns the users subscribed to the given topic, in the order they subscribed.
+func (m *Manager) Subscribers(name string) ([]SubscriberState, error) {
+	cacheService, err := m.get(name)
+	if err != nil {
+		return nil, err
+	}
+	return cacheService.Subscribers(), nil
+}
+
+// Disconnect a user from the given topic, returning broker.ErrSubscriberNotFound if it is not
+// subscribed.
+func (m *Manager) Disconnect(name string, id string) error {
+	cacheService, err := m.get(name)
+	if err != nil {
+		return err
+	}
+	if err := cacheService.Disconnect(id); err != nil {
+		return err
+	}
+	chslog.Info("Subscriber disconnected", chslog.Data{"topic": name, "subscriber": id})
+	return nil
+}
+
+// Topics returns the state of every topic, ordered by name.
+func (m *Manager) Topics() []TopicState {
+	cacheServices := m.all()
+	topics := make([]TopicState, 0, len(cacheServices))
+	for _, cacheService := range cacheServices {
+		topics = append(topics, cacheService.State())
+	}
+	return topics
+}
+
+// Health returns the components of every topic to check when determining whether the service is
+// ready.
+func (m *Manager) Health() []health.Topic {
+	cacheServices := m.all()
+	topics := make([]health.Topic, 0, len(cacheServices))
+	for _, cacheService := range cacheServices {
+		topics = append(topics, cacheService.Health())
+	}
+	return topics
+}
+
+// ServeHTTP serves the stream of the topic at the request's path, responding with 404 if there is
+// no such topic.
+func (m *Manager) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	m.mutex.RLock()
+	cacheService, ok := m.paths[request.URL.Path]
+	m.mutex.RUnlock()
+	if !ok {
+		http.NotFound(writer, request)
+		return
+	}
+	if request.Method != http.MethodGet {
+		writer.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	cacheService.endpoint(writer, request)
+}
+
+// Shutdown every topic in parallel, as Remove does, until the context is done.
+func (m *Manager) Shutdown(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, cacheService := range m.all() {
+		wg.Add(1)
+		go func(cacheService *CacheService) {
+			defer wg.Done()
+			if err := m.Remove(ctx, cacheService.Topic()); err != nil {
+				chslog.Error(err, chslog.Data{"topic": cacheService.Topic()})
+			}
+		}(cacheService)
+	}
+	wg.Wait()
+}
+
+func (m *Manager) get(name string) (*CacheService, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	cacheService, ok := m.services[name]
+	if !ok {
+		return nil, ErrTopicNotFound
+	}
+	return cacheService, nil
+}
+
+func (m *Manager) all() []*CacheService {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	cacheServices := make([]*CacheService, 0, len(m.services))
+	for _, cacheService := range m.services {
+		cacheServices = append(cacheServices, cacheService)
+	}
+	sort.Slice(cacheServices, func(i, j int) bool {
+		return cacheServices[i].Topic() < cacheServices[j].Topic()
+	})
+	return cacheServices
+}
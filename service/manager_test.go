@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/companieshouse/chs-streaming-api-cache/broker"
+	"github.com/companieshouse/chs-streaming-api-cache/config"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	redis, err := miniredis.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(redis.Close)
+	// A backend that accepts the stream but sends nothing until the connection is closed.
+	backend := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+		writer.(http.Flusher).Flush()
+		<-request.Context().Done()
+	}))
+	t.Cleanup(backend.Close)
+	return NewManager(&CacheConfiguration{
+		Configuration: &config.Config{
+			RedisUrl:      redis.Addr(),
+			RedisPoolSize: 1,
+			BackEndUrl:    backend.URL,
+		},
+	})
+}
+
+func TestManageTopics(t *testing.T) {
+	Convey("Given a manager streaming a topic", t, func() {
+		manager := newTestManager(t)
+		topic := &registry.Topic{Name: "filings", Path: "/filings", BackendPath: "/backend/filings"}
+		state, err := manager.Add(topic)
+		So(err, ShouldBeNil)
+		defer manager.Shutdown(context.Background())
+		Convey("Then the topic should be reported as running", func() {
+			So(state.Name, ShouldEqual, "filings")
+			So(state.Path, ShouldEqual, "/filings")
+			So(state.BackendPath, ShouldEqual, "/backend/filings")
+			So(state.State, ShouldEqual, StateRunning)
+			So(manager.Topics(), ShouldHaveLength, 1)
+		})
+		Convey("When a topic with the same name or path is added", func() {
+			_, sameName := manager.Add(&registry.Topic{Name: "filings", Path: "/other", BackendPath: "/backend/other"})
+			_, samePath := manager.Add(&registry.Topic{Name: "other", Path: "/filings", BackendPath: "/backend/other"})
+			Convey("Then it should be rejected", func() {
+				So(sameName, ShouldEqual, ErrTopicExists)
+				So(samePath, ShouldEqual, ErrTopicExists)
+			})
+		})
+		Convey("When an invalid topic is added", func() {
+			_, err := manager.Add(&registry.Topic{Name: "other", Path: "other"})
+			Convey("Then it should be rejected", func() {
+				So(err, ShouldNotBeNil)
+				So(manager.Topics(), ShouldHaveLength, 1)
+			})
+		})
+		Convey("When the topic is paused", func() {
+			state, err := manager.Pause("filings")
+			Convey("Then it should be reported as paused", func() {
+				So(err, ShouldBeNil)
+				So(state.State, ShouldEqual, StatePaused)
+			})
+			Convey("And when it is resumed", func() {
+				state, err := manager.Resume("filings")
+				Convey("Then it should be reported as running", func() {
+					So(err, ShouldBeNil)
+					So(state.State, ShouldEqual, StateRunning)
+				})
+			})
+		})
+		Convey("When the topic is requested", func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			response := httptest.NewRecorder()
+			manager.ServeHTTP(response, httptest.NewRequest("GET", "/filings", nil).WithContext(ctx))
+			Convey("Then its stream should be served", func() {
+				So(response.Code, ShouldEqual, http.StatusOK)
+				So(response.Header().Get("Content-Type"), ShouldEqual, "application/x-ndjson")
+			})
+		})
+		Convey("When a user is streaming the topic", func() {
+			request := httptest.NewRequest("GET", "/filings", nil)
+			request.Header.Set("X-Request-Id", "123")
+			response := httptest.NewRecorder()
+			finished := make(chan struct{})
+			go func() {
+				manager.ServeHTTP(response, request)
+				close(finished)
+			}()
+			var subscribers []SubscriberState
+			for len(subscribers) == 0 {
+				time.Sleep(time.Millisecond)
+				subscribers, err = manager.Subscribers("filings")
+			}
+			Convey("Then it should be listed among the topic's subscribers", func() {
+				So(err, ShouldBeNil)
+				So(subscribers, ShouldHaveLength, 1)
+				So(subscribers[0].RequestID, ShouldEqual, "123")
+				So(subscribers[0].RemoteAddress, ShouldEqual, request.RemoteAddr)
+				So(manager.Disconnect("filings", subscribers[0].ID), ShouldBeNil)
+				<-finished
+			})
+			Convey("And when it is disconnected", func() {
+				err := manager.Disconnect("filings", subscribers[0].ID)
+				<-finished
+				Convey("Then its stream should be ended", func() {
+					So(err, ShouldBeNil)
+					So(response.Body.String(), ShouldEndWith, "{\"end_of_stream\":true}\n")
+					remaining, _ := manager.Subscribers("filings")
+					So(remaining, ShouldBeEmpty)
+					So(manager.Disconnect("filings", subscribers[0].ID), ShouldEqual, broker.ErrSubscriberNotFound)
+				})
+			})
+		})
+		Convey("When the topic is removed", func() {
+			err := manager.Remove(context.Background(), "filings")
+			Convey("Then it should no longer be streamed", func() {
+				So(err, ShouldBeNil)
+				So(manager.Topics(), ShouldBeEmpty)
+				_, err := manager.Topic("filings")
+				So(err, ShouldEqual, ErrTopicNotFound)
+				response := httptest.NewRecorder()
+				manager.ServeHTTP(response, httptest.NewRequest("GET", "/filings", nil))
+				So(response.Code, ShouldEqual, http.StatusNotFound)
+			})
+		})
+		Convey("When an unknown topic is managed", func() {
+			_, pauseErr := manager.Pause("unknown")
+			removeErr := manager.Remove(context.Background(), "unknown")
+			Convey("Then it should not be found", func() {
+				So(pauseErr, ShouldEqual, ErrTopicNotFound)
+				So(removeErr, ShouldEqual, ErrTopicNotFound)
+			})
+		})
+	})
+}
+
+func TestAddTopicWhileRedisIsUnreachable(t *testing.T) {
+	Convey("Given a manager whose Redis cannot be reached", t, func() {
+		redis, err := miniredis.Run()
+		So(err, ShouldBeNil)
+		address := redis.Addr()
+		redis.Close()
+		manager := NewManager(&CacheConfiguration{
+			Configuration: &config.Config{
+				RedisUrl:      address,
+				RedisPoolSize: 1,
+			},
+		})
+		Convey("When a topic is added", func() {
+			_, err := manager.Add(&registry.Topic{Name: "filings", Path: "/filings", BackendPath: "/backend/filings"})
+			Convey("Then the cache should be reported as unavailable and the topic not added", func() {
+				So(errors.Is(err, ErrCacheUnavailable), ShouldBeTrue)
+				So(manager.Topics(), ShouldBeEmpty)
+				So(manager.adding, ShouldBeEmpty)
+			})
+		})
+	})
+}
+
+func TestAddTopicWhileAnotherIsBeingAdded(t *testing.T) {
+	Convey("Given a topic whose cache service is still being created", t, func() {
+		manager := newTestManager(t)
+		So(manager.reserve(&registry.Topic{Name: "filings", Path: "/filings"}), ShouldBeNil)
+		Convey("When a topic with the same name or path is added", func() {
+			_, sameName := manager.Add(&registry.Topic{Name: "filings", Path: "/other", BackendPath: "/backend/other"})
+			_, samePath := manager.Add(&registry.Topic{Name: "other", Path: "/filings", BackendPath: "/backend/other"})
+			Convey("Then it should be rejected, while the topics being streamed can still be listed", func() {
+				So(sameName, ShouldEqual, ErrTopicExists)
+				So(samePath, ShouldEqual, ErrTopicExists)
+				So(manager.Topics(), ShouldBeEmpty)
+			})
+		})
+	})
+}
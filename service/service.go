@@ -1,30 +1,50 @@
 package service
 
 import (
+	"context"
+	"fmt"
+	"github.com/companieshouse/chs-streaming-api-cache/auth"
 	"github.com/companieshouse/chs-streaming-api-cache/broker"
 	"github.com/companieshouse/chs-streaming-api-cache/cache"
 	backendclient "github.com/companieshouse/chs-streaming-api-cache/client"
 	"github.com/companieshouse/chs-streaming-api-cache/config"
 	"github.com/companieshouse/chs-streaming-api-cache/handlers"
+	"github.com/companieshouse/chs-streaming-api-cache/health"
+	"github.com/companieshouse/chs-streaming-api-cache/limits"
 	"github.com/companieshouse/chs-streaming-api-cache/logger"
 	"github.com/companieshouse/chs-streaming-api-cache/mapper"
+	"github.com/companieshouse/chs-streaming-api-cache/metrics"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
+	chslog "github.com/companieshouse/chs.go/log"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/pat"
+	"io"
 	"net/http"
+	"time"
 )
 
 const network = "tcp"
 
 type CacheService struct {
-	broker     *broker.Broker
-	client     *backendclient.Client
-	router     *pat.Router
-	topic      string
-	path       string
-	backendURL string
-	username   string
-	redisCfg   RedisConfig
-	myMapper   *mapper.ConfigurationPathMapper
+	broker        *broker.Broker
+	authenticator *auth.Authenticator
+	client        *backendclient.Client
+	handler       *handlers.RequestHandler
+	endpoint      http.HandlerFunc
+	cache         cache.Cacheable
+	cacheBackend  string
+	router        *pat.Router
+	topic         string
+	path          string
+	backendPath   string
+	backendURL    string
+	username      string
+	redisCfg      RedisConfig
+	backendCfg    BackendConfig
+	heartbeat     time.Duration
+	limitsCfg     LimitsConfig
+	myMapper      *mapper.ConfigurationPathMapper
+	stop          chan struct{}
 }
 
 type Router interface {
@@ -33,30 +53,110 @@ type Router interface {
 
 type CacheConfiguration struct {
 	Configuration *config.Config
-	Router        *pat.Router
+	// The router the streams are registered with; if nil they are served by a Manager.
+	Router *pat.Router
+	// Authenticates users of the streams; if nil the streams are open to everyone.
+	Authenticator *auth.Authenticator
+	// The topics streamed; if nil the standard topics are built from Configuration.
+	Registry *registry.Registry
 }
 
 type RedisConfig struct {
 	redisUrl        string
 	expiryInSeconds int64
 	poolSize        int
+	maxEntries      int64
+	hotMaxEntries   int64
+	pageSize        int
+	pruneInterval   time.Duration
+}
+
+const defaultPruneInterval = time.Minute
+
+type LimitsConfig struct {
+	maxStreams        int
+	replaysPerMinute  int
+	replayBurst       int
+	trustForwardedFor bool
+}
+
+type BackendConfig struct {
+	retryInterval    time.Duration
+	maxRetryInterval time.Duration
+	retryJitter      float64
+	idleTimeout      time.Duration
 }
 
 func NewCacheService(cfg *CacheConfiguration) *CacheService {
+	overflowPolicy, err := broker.NewOverflowPolicy(
+		cfg.Configuration.SubscriberOverflowPolicy,
+		time.Duration(cfg.Configuration.SubscriberBlockTimeoutInMillis)*time.Millisecond,
+	)
+	if err != nil {
+		panic(err)
+	}
+	switch cfg.Configuration.CacheBackend {
+	case "", cache.RedisBackend, cache.MemoryBackend, cache.TieredBackend:
+	default:
+		panic(fmt.Errorf("unknown cache backend [%s]", cfg.Configuration.CacheBackend))
+	}
 	return &CacheService{
-		broker:     broker.NewBroker(),
-		router:     cfg.Router,
-		backendURL: cfg.Configuration.BackEndUrl,
-		username:   cfg.Configuration.ChsApiKey,
+		broker: broker.NewBroker().
+			WithBufferSize(cfg.Configuration.SubscriberBufferSize).
+			WithOverflowPolicy(overflowPolicy),
+		authenticator: cfg.Authenticator,
+		router:        cfg.Router,
+		cacheBackend:  cfg.Configuration.CacheBackend,
+		backendURL:    cfg.Configuration.BackEndUrl,
+		username:      cfg.Configuration.ChsApiKey,
 		redisCfg: RedisConfig{
 			redisUrl:        cfg.Configuration.RedisUrl,
 			expiryInSeconds: cfg.Configuration.CacheExpiryInSeconds,
 			poolSize:        cfg.Configuration.RedisPoolSize,
+			maxEntries:      cfg.Configuration.CacheMaxEntries,
+			hotMaxEntries:   cfg.Configuration.CacheHotMaxEntries,
+			pageSize:        cfg.Configuration.CacheReadPageSize,
+			pruneInterval:   time.Duration(cfg.Configuration.CachePruneIntervalInSeconds) * time.Second,
+		},
+		backendCfg: BackendConfig{
+			retryInterval:    time.Duration(cfg.Configuration.BackendRetryInMillis) * time.Millisecond,
+			maxRetryInterval: time.Duration(cfg.Configuration.BackendMaxRetryInMillis) * time.Millisecond,
+			retryJitter:      float64(cfg.Configuration.BackendRetryJitterPercent) / 100,
+			idleTimeout:      time.Duration(cfg.Configuration.BackendIdleTimeoutInSeconds) * time.Second,
 		},
-		myMapper: mapper.New(cfg.Configuration),
+		heartbeat: time.Duration(cfg.Configuration.HeartbeatIntervalInSeconds) * time.Second,
+		limitsCfg: LimitsConfig{
+			maxStreams:        cfg.Configuration.MaxStreamsPerConsumer,
+			replaysPerMinute:  cfg.Configuration.ReplaysPerMinutePerConsumer,
+			replayBurst:       cfg.Configuration.ReplayBurstPerConsumer,
+			trustForwardedFor: cfg.Configuration.TrustForwardedFor,
+		},
+		myMapper: newMapper(cfg),
+		stop:     make(chan struct{}),
 	}
 }
 
+func newMapper(cfg *CacheConfiguration) *mapper.ConfigurationPathMapper {
+	if cfg.Registry != nil {
+		return mapper.NewFromRegistry(cfg.Registry)
+	}
+	return mapper.New(cfg.Configuration)
+}
+
+// Stream the given topic from the registry, applying any options it overrides.
+func (s *CacheService) ForTopic(topic *registry.Topic) *CacheService {
+	s.WithTopic(topic.Name).WithPath(topic.Path)
+	s.backendPath = topic.BackendPath
+	if topic.CacheExpiryInSeconds > 0 {
+		s.redisCfg.expiryInSeconds = topic.CacheExpiryInSeconds
+	}
+	if topic.CacheMaxEntries > 0 {
+		s.redisCfg.maxEntries = topic.CacheMaxEntries
+	}
+	s.broker.WithBufferSize(topic.SubscriberBufferSize)
+	return s
+}
+
 func (s *CacheService) WithTopic(topic string) *CacheService {
 	s.topic = topic
 	return s
@@ -67,36 +167,203 @@ func (s *CacheService) WithPath(path string) *CacheService {
 	return s
 }
 
-func (s *CacheService) Initialise() *CacheService {
-	cfg := s.redisCfg
-
-	cacheClient := cache.NewRedisCacheService(
-		network,
-		cfg.redisUrl,
-		cfg.poolSize,
-		cfg.expiryInSeconds,
-	)
-
-	backendPath, err := s.myMapper.GetBackendPathForPath(s.path)
+// Initialise the cache, backend client and request handler of the service, returning an error if
+// its cache cannot be created.
+func (s *CacheService) Initialise() (*CacheService, error) {
+	cacheClient, err := s.newCache()
 	if err != nil {
-		// default to same Url
-		backendPath = s.path
+		return nil, err
+	}
+
+	backendPath := s.backendPath
+	if backendPath == "" {
+		backendPath, err = s.myMapper.GetBackendPathForPath(s.path)
+		if err != nil {
+			// default to same Url
+			backendPath = s.path
+		}
+		s.backendPath = backendPath
 	}
 	s.client = backendclient.NewClient(
 		s.backendURL,
 		backendPath,
 		s.broker,
-		http.DefaultClient,
+		backendclient.NewHTTPClient(),
 		s.username,
 		cacheClient,
 		s.topic,
-		logger.NewLogger())
+		logger.NewLogger()).
+		WithBackoff(backendclient.NewBackoff(s.backendCfg.retryInterval, s.backendCfg.maxRetryInterval, s.backendCfg.retryJitter)).
+		WithIdleTimeout(s.backendCfg.idleTimeout)
 
-	s.router.Path(s.path).Methods("GET").HandlerFunc(handlers.NewRequestHandler(s.broker, cacheClient, logger.NewLogger(), s.topic).HandleRequest)
-	return s
+	s.cache = cacheClient
+	metrics.RegisterBroker(s.topic, s.broker)
+	s.handler = handlers.NewRequestHandler(s.broker, cacheClient, logger.NewLogger(), s.topic).
+		WithHeartbeat(s.heartbeat).
+		WithLimiter(limits.NewLimiter(s.limitsCfg.maxStreams, float64(s.limitsCfg.replaysPerMinute), s.limitsCfg.replayBurst)).
+		WithTrustedForwardedFor(s.limitsCfg.trustForwardedFor)
+	s.endpoint = s.handler.HandleRequest
+	if s.authenticator != nil {
+		s.endpoint = s.authenticator.Handler(s.path, s.endpoint)
+	}
+	if s.router != nil {
+		s.router.Path(s.path).Methods("GET").HandlerFunc(s.endpoint)
+	}
+	return s, nil
+}
+
+// Create the cache of the configured backend, which is Redis unless another is configured.
+func (s *CacheService) newCache() (cache.Cacheable, error) {
+	cfg := s.redisCfg
+	if s.cacheBackend == cache.MemoryBackend {
+		return cache.NewMemoryCacheService(cfg.expiryInSeconds, cfg.maxEntries, cfg.pageSize), nil
+	}
+	redis, err := cache.OpenRedisCacheService(
+		network,
+		cfg.redisUrl,
+		cfg.poolSize,
+		cfg.expiryInSeconds,
+		cfg.maxEntries,
+		cfg.pageSize,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if s.cacheBackend == cache.TieredBackend {
+		hot := cache.NewMemoryCacheService(cfg.expiryInSeconds, cfg.hotMaxEntries, cfg.pageSize).(*cache.MemoryCacheService)
+		return cache.NewTieredCacheService(hot, redis), nil
+	}
+	return redis, nil
 }
 
 func (s *CacheService) Start() {
 	go s.client.Run()
 	go s.broker.Run()
+	if pruner, ok := s.cache.(cache.Prunable); ok {
+		go s.prune(pruner)
+	}
+}
+
+// Pause ingestion from the backend. Connected users stay connected and may still replay cached
+// offsets, but receive no new deltas until the service is resumed.
+func (s *CacheService) Pause() {
+	s.client.Pause()
+}
+
+// Resume ingestion from the backend, from the offset after the last one cached.
+func (s *CacheService) Resume() {
+	s.client.Resume()
+}
+
+// Periodically prune expired offsets from the cache until the service is shut down.
+func (s *CacheService) prune(pruner cache.Prunable) {
+	interval := s.redisCfg.pruneInterval
+	if interval <= 0 {
+		interval = defaultPruneInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	log := logger.NewLogger()
+	for {
+		select {
+		case <-ticker.C:
+			pruned, err := pruner.Prune(s.topic)
+			if err != nil {
+				log.Error(err, chslog.Data{"topic": s.topic})
+				continue
+			}
+			metrics.CacheEntriesPruned.WithLabelValues(s.topic).Add(float64(pruned))
+			if pruned > 0 {
+				log.Info("Pruned expired offsets from cache", chslog.Data{"topic": s.topic, "pruned": pruned})
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Shutdown this service: new users are rejected, the backend stream is closed and connected users
+// are sent an end of stream marker. Once their streams have ended, or the context is done, the
+// cache connection pool is closed.
+func (s *CacheService) Shutdown(ctx context.Context) error {
+	close(s.stop)
+	s.handler.Close()
+	s.client.Stop()
+	s.broker.Stop()
+
+	var err error
+	select {
+	case <-s.broker.Done():
+		err = s.handler.Wait(ctx)
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if closer, ok := s.cache.(io.Closer); ok {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Health returns the components to check when determining whether this service is ready.
+func (s *CacheService) Health() health.Topic {
+	topic := health.Topic{
+		Name:   s.topic,
+		Client: s.client,
+	}
+	if pinger, ok := s.cache.(health.Pinger); ok {
+		topic.Cache = pinger
+	}
+	return topic
+}
+
+// State returns the state of this service's topic.
+func (s *CacheService) State() TopicState {
+	status := s.client.Status()
+	state := TopicState{
+		Name:        s.topic,
+		Path:        s.path,
+		BackendPath: s.backendPath,
+		State:       StateRunning,
+		Connected:   status.Connected,
+		Offset:      status.Offset,
+		Subscribers: s.broker.Stats().Subscribers,
+	}
+	if status.Paused {
+		state.State = StatePaused
+	}
+	if !status.LastReceived.IsZero() {
+		state.LastReceived = &status.LastReceived
+	}
+	return state
+}
+
+// Subscribers returns the users subscribed to this service's topic.
+func (s *CacheService) Subscribers() []SubscriberState {
+	subscribers := s.broker.Subscribers()
+	states := make([]SubscriberState, 0, len(subscribers))
+	for _, subscriber := range subscribers {
+		states = append(states, SubscriberState{
+			ID:            subscriber.ID,
+			RemoteAddress: subscriber.RemoteAddress,
+			Identity:      subscriber.Identity,
+			RequestID:     subscriber.RequestID,
+			StartedAt:     subscriber.StartedAt,
+			Timepoint:     subscriber.Timepoint,
+			MessagesSent:  subscriber.Messages(),
+			BytesSent:     subscriber.Bytes(),
+		})
+	}
+	return states
+}
+
+// Disconnect the given user from this service's topic. The user is sent an end of stream marker.
+func (s *CacheService) Disconnect(id string) error {
+	return s.broker.Disconnect(id)
+}
+
+// Topic returns the topic this service caches.
+func (s *CacheService) Topic() string {
+	return s.topic
 }
@@ -1,9 +1,13 @@
 package service
 
 import (
+	"github.com/alicebob/miniredis/v2"
+	"github.com/companieshouse/chs-streaming-api-cache/cache"
 	"github.com/companieshouse/chs-streaming-api-cache/config"
+	"github.com/companieshouse/chs-streaming-api-cache/registry"
 	"github.com/gorilla/pat"
 	. "github.com/smartystreets/goconvey/convey"
+	"io"
 	"testing"
 )
 
@@ -45,3 +49,71 @@ func TestBindTopic(t *testing.T) {
 		})
 	})
 }
+
+func TestBindRegisteredTopic(t *testing.T) {
+	Convey("Given a new service instance has been constructed from a registry", t, func() {
+		topic := &registry.Topic{Name: "topic", Path: "/topic", BackendPath: "/backend/topic", CacheMaxEntries: 10}
+		configuration := &CacheConfiguration{
+			Configuration: &config.Config{
+				RedisUrl:             "localhost:6379",
+				CacheExpiryInSeconds: 2,
+			},
+			Router:   pat.New(),
+			Registry: &registry.Registry{Topics: []*registry.Topic{topic}},
+		}
+
+		service := NewCacheService(configuration)
+		Convey("When a registered topic is bound to it", func() {
+			actual := service.ForTopic(topic)
+			Convey("Then the topic, its path and its options should be added to the service", func() {
+				So(actual, ShouldEqual, service)
+				So(actual.topic, ShouldEqual, "topic")
+				So(actual.path, ShouldEqual, "/topic")
+				So(actual.redisCfg.expiryInSeconds, ShouldEqual, 2)
+				So(actual.redisCfg.maxEntries, ShouldEqual, 10)
+				backendPath, err := actual.myMapper.GetBackendPathForPath("/topic")
+				So(err, ShouldBeNil)
+				So(backendPath, ShouldEqual, "/backend/topic")
+			})
+		})
+	})
+}
+
+func TestSelectCacheBackend(t *testing.T) {
+	Convey("Given a service configured to cache in memory", t, func() {
+		service := NewCacheService(&CacheConfiguration{
+			Configuration: &config.Config{CacheBackend: cache.MemoryBackend, CacheMaxEntries: 10},
+		})
+		Convey("When its cache is created", func() {
+			actual, err := service.newCache()
+			Convey("Then an in-memory cache should be returned", func() {
+				So(err, ShouldBeNil)
+				So(actual, ShouldHaveSameTypeAs, &cache.MemoryCacheService{})
+			})
+		})
+	})
+	Convey("Given a service configured to cache recent offsets in memory in front of redis", t, func() {
+		redis, err := miniredis.Run()
+		So(err, ShouldBeNil)
+		defer redis.Close()
+		service := NewCacheService(&CacheConfiguration{
+			Configuration: &config.Config{CacheBackend: cache.TieredBackend, RedisUrl: redis.Addr(), RedisPoolSize: 1, CacheHotMaxEntries: 10},
+		})
+		Convey("When its cache is created", func() {
+			actual, err := service.newCache()
+			So(err, ShouldBeNil)
+			defer actual.(io.Closer).Close()
+			Convey("Then a tiered cache should be returned", func() {
+				So(actual, ShouldHaveSameTypeAs, &cache.TieredCacheService{})
+			})
+		})
+	})
+	Convey("Given a service configured with an unknown cache backend", t, func() {
+		configuration := &CacheConfiguration{
+			Configuration: &config.Config{CacheBackend: "disk"},
+		}
+		Convey("Then the service should not be created", func() {
+			So(func() { NewCacheService(configuration) }, ShouldPanic)
+		})
+	})
+}